@@ -0,0 +1,372 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a memo subcommand.
+type command struct {
+	Name string
+	// Usage is the arguments after the command's name, as in "<id>".
+	Usage   string
+	Summary string
+	// Help says more, for memo help and the man page.
+	Help string
+	// Text marks commands whose arguments are free-form text, where flags
+	// are only taken before the text. Other commands take them anywhere.
+	Text bool
+	// Raw commands get their arguments as they are, flags and all.
+	Raw    bool
+	Hidden bool
+	// MinArgs and MaxArgs bound the number of arguments; a negative MaxArgs
+	// means there's no limit.
+	MinArgs, MaxArgs int
+	// Complete gives what each argument is, for shell completion: see
+	// kindCandidates.
+	Complete []string
+	// Setup registers the command's flags and returns the function that
+	// runs it with the arguments left once they're parsed.
+	Setup    func(fs *flag.FlagSet) func(args []string)
+	Commands []*command
+
+	parent *command
+}
+
+// path is the command's name with its parents', as in "context use".
+func (c *command) path() string {
+	if c.parent == nil {
+		return c.Name
+	}
+	return c.parent.path() + " " + c.Name
+}
+
+// synopsis is how the command is used, as in "memo done <id>".
+func (c *command) synopsis() string {
+	s := "memo " + c.path()
+	if len(c.Commands) > 0 && c.Usage == "" {
+		if c.Setup != nil {
+			s += " [<command>]"
+		} else {
+			s += " <command>"
+		}
+	}
+	if c.hasFlags() {
+		s += " [options]"
+	}
+	if c.Usage != "" {
+		s += " " + c.Usage
+	}
+	return s
+}
+
+func (c *command) hasFlags() bool {
+	found := false
+	fs, _ := c.setup()
+	fs.VisitAll(func(f *flag.Flag) {
+		found = found || !globalFlags[f.Name]
+	})
+	return found
+}
+
+// setup returns the command's flags, with the global ones, and the function
+// that runs it, if it has one.
+func (c *command) setup() (*flag.FlagSet, func([]string)) {
+	fs := flag.NewFlagSet(c.path(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&jsonOutput, "json", jsonOutput, "print JSON instead of text (also MEMO_FORMAT=json)")
+	fs.StringVar(&selectedContext, "context", selectedContext, "act on the named `context` instead of the current one (also MEMO_CONTEXT)")
+	if c.Setup == nil || c.Raw {
+		return fs, nil
+	}
+	return fs, c.Setup(fs)
+}
+
+// globalFlags are the flags every command takes.
+var globalFlags = map[string]bool{"json": true, "context": true}
+
+// sub returns the subcommand called name, or nil.
+func (c *command) sub(name string) *command {
+	return findCommand(c.Commands, name)
+}
+
+func findCommand(cmds []*command, name string) *command {
+	for _, c := range cmds {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// lookupCommand finds the command with the given path, or nil.
+func lookupCommand(path string) *command {
+	var cmd *command
+	cmds := commands
+	for _, name := range strings.Fields(path) {
+		if cmd = findCommand(cmds, name); cmd == nil {
+			return nil
+		}
+		cmds = cmd.Commands
+	}
+	return cmd
+}
+
+func linkCommands(parent *command, cmds []*command) {
+	for _, c := range cmds {
+		c.parent = parent
+		linkCommands(c, c.Commands)
+	}
+}
+
+// runCommand runs cmd, or the subcommand named by its first argument.
+func runCommand(cmd *command, args []string) {
+	if len(args) > 0 && len(cmd.Commands) > 0 {
+		if sub := cmd.sub(args[0]); sub != nil {
+			runCommand(sub, args[1:])
+			return
+		}
+	}
+	if cmd.Raw {
+		cmd.Setup(nil)(args)
+		return
+	}
+	fs, run := cmd.setup()
+	args = parseArgs(fs, args, cmd.Text)
+	if len(cmd.Commands) > 0 && len(args) > max(cmd.MaxArgs, 0) {
+		usageError(cmd, fmt.Sprintf("unknown command %q", args[0]))
+	}
+	if len(args) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(args) > cmd.MaxArgs) {
+		usageError(cmd, "wrong number of arguments")
+	}
+	run(args)
+}
+
+// parseArgs parses a command's flags and returns its other arguments. A
+// command taking free text only takes flags before the text; others take
+// them anywhere. "--" ends the flags either way.
+func parseArgs(fs *flag.FlagSet, args []string, text bool) []string {
+	cmd := lookupCommand(fs.Name())
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCommandHelp(os.Stdout, cmd)
+				os.Exit(0)
+			}
+			msg := err.Error()
+			if text {
+				msg += ` (put "--" before text starting with "-")`
+			}
+			usageError(cmd, msg)
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		if text || endedFlags(args, rest) {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// endedFlags reports whether parsing args stopped at "--" rather than at the
+// argument rest starts with.
+func endedFlags(args, rest []string) bool {
+	n := len(args) - len(rest)
+	return n > 0 && args[n-1] == "--"
+}
+
+// usageError reports a mistake in how cmd was run and exits.
+func usageError(cmd *command, msg string) {
+	failUsage(fmt.Sprintf("memo %s: %s\nUsage: %s\nRun \"memo help %s\" for more.", cmd.path(), msg, cmd.synopsis(), cmd.path()))
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// flagLines describes fs's flags, the global ones only if global is set,
+// one "--name <value>", usage pair per flag.
+func flagLines(fs *flag.FlagSet, global bool) [][2]string {
+	var lines [][2]string
+	fs.VisitAll(func(f *flag.Flag) {
+		if globalFlags[f.Name] != global {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		left := "--" + f.Name
+		if name != "" {
+			left += " <" + name + ">"
+		}
+		if def := f.DefValue; def != "" && def != "false" && def != "0" && def != "[]" && !globalFlags[f.Name] {
+			usage += fmt.Sprintf(" (default %s)", def)
+		}
+		lines = append(lines, [2]string{left, usage})
+	})
+	return lines
+}
+
+func printFlagLines(w io.Writer, lines [][2]string) {
+	for _, l := range lines {
+		if len(l[0]) > 22 {
+			fmt.Fprintf(w, "  %s\n  %-22s %s\n", l[0], "", l[1])
+		} else {
+			fmt.Fprintf(w, "  %-22s %s\n", l[0], l[1])
+		}
+	}
+}
+
+func printUsage() {
+	w := os.Stdout
+	fmt.Fprintln(w, `memo - task stack manager
+
+Usage:
+  memo                    Show the current task
+  memo [options] <command> [arguments]
+
+Commands:`)
+	for _, c := range commands {
+		if !c.Hidden {
+			fmt.Fprintf(w, "  %-11s %s\n", c.Name, c.Summary)
+		}
+	}
+	fmt.Fprintln(w, "\nOptions:")
+	fs, _ := (&command{}).setup()
+	printFlagLines(w, flagLines(fs, true))
+	fmt.Fprintln(w, `
+Run "memo help <command>" for a command's arguments and options.
+
+Exit codes:
+  1  error
+  2  bad request (usage error, unknown task or context)
+  3  stack empty
+  4  daemon unreachable`)
+}
+
+func printCommandHelp(w io.Writer, cmd *command) {
+	fmt.Fprintf(w, "Usage: %s\n", cmd.synopsis())
+	fmt.Fprintf(w, "\n%s\n", cmd.Summary)
+	if cmd.Help != "" {
+		fmt.Fprintf(w, "\n%s\n", cmd.Help)
+	}
+	if len(cmd.Commands) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		for _, c := range cmd.Commands {
+			fmt.Fprintf(w, "  %-22s %s\n", strings.TrimPrefix(c.synopsis(), "memo "+cmd.path()+" "), c.Summary)
+		}
+	}
+	fs, _ := cmd.setup()
+	if lines := flagLines(fs, false); len(lines) > 0 {
+		fmt.Fprintln(w, "\nOptions:")
+		printFlagLines(w, lines)
+	}
+}
+
+// runHelp prints help for the command named by args, or for memo.
+func runHelp(args []string) {
+	if len(args) == 0 {
+		printUsage()
+		return
+	}
+	cmd := lookupCommand(strings.Join(args, " "))
+	if cmd == nil {
+		failUsage(fmt.Sprintf("memo help: unknown command %q", strings.Join(args, " ")))
+	}
+	printCommandHelp(os.Stdout, cmd)
+}
+
+// writeManPage writes memo's man page, in roff, to w.
+func writeManPage(w io.Writer) {
+	esc := func(s string) string {
+		s = strings.ReplaceAll(s, `\`, `\e`)
+		s = strings.ReplaceAll(s, "-", `\-`)
+		var lines []string
+		for _, line := range strings.Split(s, "\n") {
+			if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+				line = `\&` + line
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	flags := func(lines [][2]string) {
+		for _, l := range lines {
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", esc(l[0]), esc(l[1]))
+		}
+	}
+
+	fmt.Fprintf(w, ".TH MEMO 1 %q \"memo %s\" \"User Commands\"\n", time.Now().Format("2006-01-02"), Version)
+	fmt.Fprintln(w, ".SH NAME\nmemo \\- task stack manager")
+	fmt.Fprintln(w, ".SH SYNOPSIS\n.B memo\n[\\fIoptions\\fR] [\\fIcommand\\fR] [\\fIarguments\\fR]")
+	fmt.Fprintln(w, ".SH DESCRIPTION\nmemo keeps a stack of tasks: push a task when something interrupts you and pop it when you're done to get back to what you were doing. A small daemon, started on first use, holds the stack and logs the time spent on each task.\nRun without a command, memo shows the current task.")
+	fmt.Fprintln(w, ".SH OPTIONS")
+	global, _ := (&command{}).setup()
+	flags(flagLines(global, true))
+	fmt.Fprintln(w, ".SH COMMANDS")
+	var walk func(cmds []*command)
+	walk = func(cmds []*command) {
+		for _, c := range cmds {
+			if c.Hidden {
+				continue
+			}
+			fmt.Fprintf(w, ".SS %s\n%s\n", esc(c.synopsis()), esc(c.Summary))
+			if c.Help != "" {
+				fmt.Fprintf(w, ".PP\n%s\n", esc(c.Help))
+			}
+			fs, _ := c.setup()
+			if lines := flagLines(fs, false); len(lines) > 0 {
+				fmt.Fprintln(w, ".RS")
+				flags(lines)
+				fmt.Fprintln(w, ".RE")
+			}
+			walk(c.Commands)
+		}
+	}
+	walk(commands)
+	fmt.Fprintln(w, `.SH ENVIRONMENT
+.TP
+.B MEMO_HOME
+Directory for the config file and data, instead of the XDG directories or ~/.memo.
+.TP
+.B MEMO_CONTEXT
+Context to act on, like \-\-context.
+.TP
+.B MEMO_FORMAT
+Set to json to print JSON, like \-\-json.
+.TP
+.B MEMO_IDLE_AFTER, MEMO_IDLE_RETURN, MEMO_IDLE_PROBE
+Override the idle settings.
+.SH FILES
+.TP
+.I ~/.memo/config.toml
+Settings; see memo help config.
+.TP
+.I ~/.memo/state.json, ~/.memo/log.jsonl
+Task stacks and the work log.
+.SH EXIT STATUS
+.TP
+.B 1
+error
+.TP
+.B 2
+bad request (usage error, unknown task or context)
+.TP
+.B 3
+stack empty
+.TP
+.B 4
+daemon unreachable`)
+}
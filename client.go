@@ -1,18 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type memoClient struct {
 	http *http.Client
+	// context names the context requests target. Empty means the daemon's
+	// current context.
+	context string
 }
 
 func newClient() *memoClient {
@@ -28,46 +37,133 @@ func newClient() *memoClient {
 	}
 }
 
-func (c *memoClient) Stack() {
-	resp, err := c.http.Get("http://memo/stack")
+// url returns the daemon URL for path, scoped to the client's context.
+func (c *memoClient) url(path string) string {
+	u := "http://memo" + path
+	if c.context != "" {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		u += sep + "context=" + url.QueryEscape(c.context)
+	}
+	return u
+}
+
+// call sends a request to the daemon and decodes the JSON response into out,
+// if out is non-nil. Error responses are returned as *apiError.
+func (c *memoClient) call(method, path string, body, out any) error {
+	return c.callAt(-1, method, path, body, out)
+}
+
+// callAt is call for a change worked out from the given revision of the
+// stack. The daemon refuses it with 409 Conflict if the stack has changed
+// since; a negative revision skips the check.
+func (c *memoClient) callAt(revision int64, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url(path), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if revision >= 0 {
+		req.Header.Set("If-Match", fmt.Sprintf("%q", strconv.FormatInt(revision, 10)))
+	}
+	resp, err := c.http.Do(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 	defer resp.Body.Close()
 
-	var stack TaskStack
-	if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return serverError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// serverError turns an error response from the daemon into an *apiError
+// carrying the daemon's message.
+func serverError(resp *http.Response) error {
+	msg, _ := io.ReadAll(resp.Body)
+	s := strings.TrimSpace(string(msg))
+	if s == "" {
+		s = fmt.Sprintf("server returned %s", resp.Status)
+	}
+	return &apiError{Status: resp.StatusCode, Message: s}
+}
+
+// Stack prints the tasks on the stack that match filter.
+func (c *memoClient) Stack(filter taskFilter) {
+	stack, err := c.FetchStack()
+	if err != nil {
+		failErr(err)
+	}
+
+	var tasks []Task
+	for _, task := range stack.List() {
+		if filter.Match(task) {
+			tasks = append(tasks, task)
+		}
+	}
+
+	now := time.Now()
+	if jsonOutput {
+		printJSON(tasksJSON(tasks, now))
+		return
 	}
 
 	if stack.Len() == 0 {
 		fmt.Println("No tasks. Use \"memo push <description>\" to start one.")
 		return
 	}
+	if len(tasks) == 0 {
+		fmt.Printf("No tasks matching %s.\n", filter)
+		return
+	}
 
-	for i, task := range stack.List() {
-		if i == 0 {
-			fmt.Printf("\u2192 %s (working for %s)\n", task.Description, formatDuration(time.Since(task.StartedAt)))
+	for _, task := range tasks {
+		if task.ID == stack.Tasks[0].ID {
+			fmt.Printf("\u2192 %s %s (%s)\n", task.ID, task.Label(), workingFor(task, now))
+		} else if active := task.Active(now); active > 0 {
+			fmt.Printf("  %s %s (paused, worked %s)\n", task.ID, task.Label(), formatDuration(active))
 		} else {
-			fmt.Printf("  %s (paused)\n", task.Description)
+			fmt.Printf("  %s %s (paused)\n", task.ID, task.Label())
 		}
 	}
 }
 
 func (c *memoClient) Current() {
-	resp, err := c.http.Get("http://memo/stack")
+	stack, err := c.FetchStack()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	var stack TaskStack
-	if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	idle, _ := c.FetchIdle()
+	focus, _ := c.FetchFocus()
+
+	now := time.Now()
+	if jsonOutput {
+		printJSON(struct {
+			Task  *taskOutput   `json:"task"`
+			Idle  *IdleState    `json:"idle,omitempty"`
+			Focus *FocusSession `json:"focus,omitempty"`
+		}{taskJSON(stack.Peek(), now), idle, focus})
+		return
+	}
+	if focus != nil && focus.Break {
+		fmt.Printf("On a break (%s left)\n", formatDuration(focus.Remaining(now)))
 	}
 
 	if stack.Len() == 0 {
@@ -76,248 +172,761 @@ func (c *memoClient) Current() {
 	}
 
 	top := stack.List()[0]
-	fmt.Printf("%s (working for %s)\n", top.Description, formatDuration(time.Since(top.StartedAt)))
+	if idle != nil && idle.Returned == nil {
+		fmt.Printf("%s (paused, away since %s)\n", top.Label(), formatClock(idle.Since))
+		return
+	}
+	if focus != nil && !focus.Break {
+		fmt.Printf("%s (%s, focus %s left)\n", top.Label(), workingFor(top, now), formatDuration(focus.Remaining(now)))
+	} else {
+		fmt.Printf("%s (%s)\n", top.Label(), workingFor(top, now))
+	}
+	if idle != nil {
+		printAway(idle)
+	}
 }
 
-func (c *memoClient) Push(description string) {
-	body := strings.NewReader(fmt.Sprintf(`{"description":%q}`, description))
-	resp, err := c.http.Post("http://memo/push", "application/json", body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+// FetchFocus returns the running focus session or break, or nil.
+func (c *memoClient) FetchFocus() (*FocusSession, error) {
+	var result struct {
+		Focus *FocusSession `json:"focus"`
 	}
-	defer resp.Body.Close()
+	if err := c.call("GET", "/focus", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Focus, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
-		os.Exit(1)
+// Focus starts a focus session on the current task. An empty length means
+// the daemon's default.
+func (c *memoClient) Focus(length string) {
+	var result struct {
+		Focus FocusSession `json:"focus"`
+	}
+	if err := c.call("POST", "/focus", map[string]string{"length": length}, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) && length == "" {
+			failEmpty("No task to focus on.")
+		}
+		failErr(err)
 	}
 
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Focusing: %s for %s, until %s\n", result.Focus.Task, formatDuration(result.Focus.Length()), formatClock(result.Focus.End))
+}
+
+func (c *memoClient) StopFocus() {
 	var result struct {
-		Started Task  `json:"started"`
-		Paused  *Task `json:"paused,omitempty"`
+		Stopped FocusSession `json:"stopped"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	if err := c.call("POST", "/focus/stop", nil, &result); err != nil {
+		failErr(err)
 	}
 
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	fmt.Printf("Stopped focusing: %s (%s of %s)\n", result.Stopped.Task,
+		formatDuration(time.Since(result.Stopped.Start)), formatDuration(result.Stopped.Length()))
+}
+
+// Break pauses the current task for a break. An empty length means the
+// daemon's default.
+func (c *memoClient) Break(length string) {
+	var result struct {
+		Focus  FocusSession `json:"focus"`
+		Paused *Task        `json:"paused"`
+	}
+	if err := c.call("POST", "/break", map[string]string{"length": length}, &result); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
 	if result.Paused != nil {
-		fmt.Printf("Paused: %s\n", result.Paused.Description)
+		fmt.Printf("Paused: %s\n", result.Paused.Label())
 	}
-	fmt.Printf("Started: %s\n", result.Started.Description)
+	fmt.Printf("Break: %s, until %s\n", formatDuration(result.Focus.Length()), formatClock(result.Focus.End))
 }
 
-func (c *memoClient) Pop() {
-	resp, err := c.http.Post("http://memo/pop", "application/json", nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func (c *memoClient) EndBreak() {
+	var result struct {
+		Ended    FocusSession `json:"ended"`
+		Resuming *Task        `json:"resuming"`
+	}
+	if err := c.call("POST", "/break/end", nil, &result); err != nil {
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusBadRequest {
-		fmt.Println("No tasks to pop.")
+	if jsonOutput {
+		printJSON(result)
 		return
 	}
+	fmt.Printf("Break over (%s)\n", formatDuration(time.Since(result.Ended.Start)))
+	if result.Resuming != nil {
+		fmt.Printf("Resuming: %s\n", result.Resuming.Label())
+		printNotes(result.Resuming)
+	}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
-		os.Exit(1)
+// FetchIdle returns the current or unsettled spell away, or nil.
+func (c *memoClient) FetchIdle() (*IdleState, error) {
+	var result struct {
+		Idle *IdleState `json:"idle"`
+	}
+	if err := c.call("GET", "/idle", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Idle, nil
+}
+
+// printAway asks what to do with a spell away that hasn't been settled.
+func printAway(idle *IdleState) {
+	fmt.Printf("You were away %s–%s (%s) from %s.\n",
+		formatClock(idle.Since), formatClock(*idle.Returned),
+		formatDuration(idle.Away(time.Now())), idle.Task)
+	fmt.Println("Keep that time with \"memo back keep\", drop it with \"memo back discard\", or give it to another task with \"memo back reassign <id>\".")
+}
+
+// Idle pauses the current task until memo back, or any change to the stack.
+func (c *memoClient) Idle() {
+	var result struct {
+		Idle *IdleState `json:"idle"`
+	}
+	if err := c.call("POST", "/idle", nil, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) {
+			failEmpty("No task is running.")
+		}
+		failErr(err)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
 	}
+	fmt.Printf("Paused: %s\n", result.Idle.Task)
+	fmt.Println("Away. Use \"memo back\" when you're back.")
+}
 
+// Back ends a spell away and keeps, discards or reassigns the time away. With
+// no action, the daemon's configured policy decides.
+func (c *memoClient) Back(action, id string) {
 	var result struct {
-		Popped   Task  `json:"popped"`
-		Resuming *Task `json:"resuming,omitempty"`
+		Away     IdleState  `json:"away"`
+		Action   string     `json:"action"`
+		Task     *Task      `json:"task"`
+		Idle     *IdleState `json:"idle"`
+		Resuming *Task      `json:"resuming"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	if err := c.call("POST", "/back", map[string]string{"action": action, "id": id}, &result); err != nil {
+		failErr(err)
 	}
 
-	duration := time.Since(result.Popped.StartedAt)
-	fmt.Printf("Done: %s (%s)\n", result.Popped.Description, formatDuration(duration))
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
 
 	if result.Resuming != nil {
-		fmt.Printf("Resuming: %s\n", result.Resuming.Description)
-	} else {
-		fmt.Println("No more tasks.")
+		fmt.Printf("Resuming: %s\n", result.Resuming.Label())
+	}
+	away := formatDuration(result.Away.Away(time.Now()))
+	switch {
+	case result.Idle != nil:
+		printAway(result.Idle)
+	case result.Action == "reassign":
+		fmt.Printf("Reassigned: %s away to %s\n", away, result.Task.Label())
+	case result.Task != nil:
+		fmt.Printf("Kept: %s away on %s\n", away, result.Task.Label())
+	default:
+		fmt.Printf("Discarded: %s away\n", away)
 	}
 }
 
-func (c *memoClient) Drop() {
-	resp, err := c.http.Post("http://memo/drop", "application/json", nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func (c *memoClient) Push(description string, tags []string, project, note string) {
+	var result struct {
+		Started Task  `json:"started"`
+		Paused  *Task `json:"paused,omitempty"`
+	}
+	body := map[string]any{"description": description, "tags": tags, "project": project, "note": note}
+	if err := c.call("POST", "/push", body, &result); err != nil {
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusBadRequest {
-		fmt.Println("No tasks to drop.")
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Started *taskOutput `json:"started"`
+			Paused  *taskOutput `json:"paused"`
+		}{taskJSON(&result.Started, now), taskJSON(result.Paused, now)})
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
-		os.Exit(1)
+	if result.Paused != nil {
+		fmt.Printf("Paused: %s\n", result.Paused.Label())
 	}
+	fmt.Printf("Started: %s\n", result.Started.Label())
+}
 
+func (c *memoClient) Pop(id string) {
 	var result struct {
-		Dropped  Task  `json:"dropped"`
+		Popped   Task  `json:"popped"`
 		Resuming *Task `json:"resuming,omitempty"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	if err := c.call("POST", "/pop", map[string]string{"id": id}, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) {
+			failEmpty("No tasks to pop.")
+		}
+		failErr(err)
 	}
 
-	duration := time.Since(result.Dropped.StartedAt)
-	fmt.Printf("Dropped: %s (%s)\n", result.Dropped.Description, formatDuration(duration))
+	now := time.Now()
+	if jsonOutput {
+		printJSON(struct {
+			Popped   *taskOutput `json:"popped"`
+			Resuming *taskOutput `json:"resuming"`
+		}{taskJSON(&result.Popped, now), taskJSON(result.Resuming, now)})
+		return
+	}
+
+	duration := result.Popped.Active(now)
+	fmt.Printf("Done: %s (%s)\n", result.Popped.Description, formatDuration(duration))
 
 	if result.Resuming != nil {
 		fmt.Printf("Resuming: %s\n", result.Resuming.Description)
-	} else {
+		printNotes(result.Resuming)
+	} else if id == "" {
 		fmt.Println("No more tasks.")
 	}
 }
 
-func (c *memoClient) Switch() {
-	resp, err := c.http.Post("http://memo/switch", "application/json", nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func (c *memoClient) Drop(id string) {
+	var result struct {
+		Dropped  Task  `json:"dropped"`
+		Resuming *Task `json:"resuming,omitempty"`
+	}
+	if err := c.call("POST", "/drop", map[string]string{"id": id}, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) {
+			failEmpty("No tasks to drop.")
+		}
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusBadRequest {
-		fmt.Println("Need at least 2 tasks to switch.")
+	now := time.Now()
+	if jsonOutput {
+		printJSON(struct {
+			Dropped  *taskOutput `json:"dropped"`
+			Resuming *taskOutput `json:"resuming"`
+		}{taskJSON(&result.Dropped, now), taskJSON(result.Resuming, now)})
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
-		os.Exit(1)
+	duration := result.Dropped.Active(now)
+	fmt.Printf("Dropped: %s (%s)\n", result.Dropped.Description, formatDuration(duration))
+
+	if result.Resuming != nil {
+		fmt.Printf("Resuming: %s\n", result.Resuming.Description)
+		printNotes(result.Resuming)
+	} else if id == "" {
+		fmt.Println("No more tasks.")
 	}
+}
 
+func (c *memoClient) Switch() {
 	var result struct {
 		Started Task `json:"started"`
 		Paused  Task `json:"paused"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	if err := c.call("POST", "/switch", nil, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) {
+			failEmpty("Need at least 2 tasks to switch.")
+		}
+		failErr(err)
+	}
+
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Started *taskOutput `json:"started"`
+			Paused  *taskOutput `json:"paused"`
+		}{taskJSON(&result.Started, now), taskJSON(&result.Paused, now)})
+		return
 	}
 
-	fmt.Printf("Paused: %s\n", result.Paused.Description)
+	fmt.Printf("Paused: %s\n", result.Paused.Label())
 	fmt.Printf("Resuming: %s\n", result.Started.Description)
+	printNotes(&result.Started)
+}
+
+func (c *memoClient) Resume(id string) {
+	var result struct {
+		Started Task  `json:"started"`
+		Paused  *Task `json:"paused,omitempty"`
+	}
+	if err := c.call("POST", "/resume", map[string]string{"id": id}, &result); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Started *taskOutput `json:"started"`
+			Paused  *taskOutput `json:"paused"`
+		}{taskJSON(&result.Started, now), taskJSON(result.Paused, now)})
+		return
+	}
+
+	if result.Paused == nil {
+		fmt.Printf("Already working on: %s\n", result.Started.Description)
+		return
+	}
+	fmt.Printf("Paused: %s\n", result.Paused.Label())
+	fmt.Printf("Resuming: %s\n", result.Started.Description)
+	printNotes(&result.Started)
+}
+
+// Insert adds a task at a 1-based position from the top, or right below the
+// task with ID after if it is set.
+func (c *memoClient) Insert(description string, tags []string, project string, position int, after, note string) {
+	var result struct {
+		Inserted Task  `json:"inserted"`
+		Position int   `json:"position"`
+		Paused   *Task `json:"paused,omitempty"`
+	}
+	body := map[string]any{
+		"description": description,
+		"tags":        tags,
+		"project":     project,
+		"position":    position,
+		"after":       after,
+		"note":        note,
+	}
+	if err := c.call("POST", "/insert", body, &result); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Inserted *taskOutput `json:"inserted"`
+			Position int         `json:"position"`
+			Paused   *taskOutput `json:"paused"`
+		}{taskJSON(&result.Inserted, now), result.Position, taskJSON(result.Paused, now)})
+		return
+	}
+
+	if result.Position == 1 {
+		if result.Paused != nil {
+			fmt.Printf("Paused: %s\n", result.Paused.Label())
+		}
+		fmt.Printf("Started: %s\n", result.Inserted.Label())
+		return
+	}
+	fmt.Printf("Inserted: %s (position %d)\n", result.Inserted.Label(), result.Position)
 }
 
-func (c *memoClient) Queue(description string) {
-	body := strings.NewReader(fmt.Sprintf(`{"description":%q}`, description))
-	resp, err := c.http.Post("http://memo/queue", "application/json", body)
+// Edit changes the description of the task with the given ID or 1-based
+// position, or of the current task if neither is given.
+func (c *memoClient) Edit(id string, position int, description string) {
+	result, err := c.EditTask(id, position, description)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		if isStatus(err, http.StatusBadRequest) && id == "" && position == 0 {
+			failEmpty("No task to edit.")
+		}
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
-		os.Exit(1)
+	if jsonOutput {
+		printJSON(struct {
+			Task     *taskOutput `json:"task"`
+			Previous string      `json:"previous"`
+		}{taskJSON(&result.Task, time.Now()), result.Previous})
+		return
+	}
+
+	if result.Task.Label() == result.Previous {
+		fmt.Printf("Unchanged: %s\n", result.Previous)
+		return
+	}
+	fmt.Printf("Edited: %s \u2192 %s\n", result.Previous, result.Task.Label())
+}
+
+// Note adds a note to the task with the given ID, or the current task if id
+// is empty.
+func (c *memoClient) Note(id, text string) {
+	var result struct {
+		Task Task `json:"task"`
+	}
+	if err := c.call("POST", "/note", map[string]string{"id": id, "text": text}, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) && id == "" {
+			failEmpty("No task to add a note to.")
+		}
+		failErr(err)
+	}
+
+	if jsonOutput {
+		printJSON(struct {
+			Task *taskOutput `json:"task"`
+		}{taskJSON(&result.Task, time.Now())})
+		return
 	}
 
+	fmt.Printf("Noted on: %s\n", result.Task.Description)
+}
+
+func (c *memoClient) Queue(description string, tags []string, project string) {
 	var result struct {
 		Queued  Task  `json:"queued"`
 		Current *Task `json:"current,omitempty"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	body := map[string]any{"description": description, "tags": tags, "project": project}
+	if err := c.call("POST", "/queue", body, &result); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Queued  *taskOutput `json:"queued"`
+			Current *taskOutput `json:"current"`
+		}{taskJSON(&result.Queued, now), taskJSON(result.Current, now)})
+		return
 	}
 
-	fmt.Printf("Queued: %s\n", result.Queued.Description)
+	fmt.Printf("Queued: %s\n", result.Queued.Label())
 }
 
-func (c *memoClient) Reorder(order []int) error {
-	orderJSON, err := json.Marshal(struct {
-		Order []int `json:"order"`
-	}{Order: order})
-	if err != nil {
-		return err
+func (c *memoClient) Undo() {
+	c.journalStep("undo", "Undid", "Nothing to undo.")
+}
+
+func (c *memoClient) Redo() {
+	c.journalStep("redo", "Redid", "Nothing to redo.")
+}
+
+func (c *memoClient) journalStep(endpoint, verb, empty string) {
+	var result struct {
+		Op      string `json:"op"`
+		Context string `json:"context"`
+		Task    string `json:"task"`
+		Current *Task  `json:"current,omitempty"`
 	}
-	resp, err := c.http.Post("http://memo/reorder", "application/json", strings.NewReader(string(orderJSON)))
-	if err != nil {
-		return err
+	if err := c.call("POST", "/"+endpoint, nil, &result); err != nil {
+		if isStatus(err, http.StatusBadRequest) {
+			fail(cliError{Exit: exitBadRequest, Code: "nothing_to_" + endpoint, Message: empty})
+		}
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned %s", resp.Status)
+	if jsonOutput {
+		printJSON(struct {
+			Op      string      `json:"op"`
+			Context string      `json:"context"`
+			Task    string      `json:"task"`
+			Current *taskOutput `json:"current"`
+		}{result.Op, result.Context, result.Task, taskJSON(result.Current, time.Now())})
+		return
+	}
+
+	fmt.Printf("%s %s: %s\n", verb, result.Op, result.Task)
+	if result.Current != nil {
+		fmt.Printf("Current: %s\n", result.Current.Description)
+	} else {
+		fmt.Println("No more tasks.")
 	}
-	return nil
 }
 
-func (c *memoClient) FetchStack() (*TaskStack, error) {
-	resp, err := c.http.Get("http://memo/stack")
+// Watch prints stack events from the daemon as they happen, until the
+// connection closes. In --json mode each event is printed as one line of
+// JSON.
+func (c *memoClient) Watch() {
+	events, err := c.Subscribe()
 	if err != nil {
-		return nil, err
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	var stack TaskStack
-	if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
+	for e := range events {
+		if jsonOutput {
+			data, _ := json.Marshal(e)
+			fmt.Println(string(data))
+			continue
+		}
+		line := fmt.Sprintf("[%s] %-10s", e.At.Local().Format("15:04:05"), e.Type)
+		switch {
+		case e.Task != nil:
+			line += " " + e.Task.Description
+		case e.Next != nil:
+			line += " " + e.Next.Description + " on top"
+		}
+		if e.Context != defaultContext {
+			line += " [" + e.Context + "]"
+		}
+		fmt.Println(line)
+	}
+}
+
+// Subscribe streams stack events from the daemon. The channel is closed when
+// the connection is.
+func (c *memoClient) Subscribe() (<-chan Event, error) {
+	resp, err := c.http.Get(c.url("/events"))
+	if err != nil {
 		return nil, err
 	}
-	return &stack, nil
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, serverError(resp)
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				continue
+			}
+			events <- e
+		}
+	}()
+	return events, nil
 }
 
-func (c *memoClient) fetchLog() []LogEntry {
-	resp, err := c.http.Get("http://memo/log")
+func (c *memoClient) TestHook(name string) {
+	typ, ok := hookEvent(name)
+	if !ok {
+		failUsage(fmt.Sprintf("Unknown hook: %s", name))
+	}
+	if _, err := os.Stat(filepath.Join(hooksDir(), hookNames[typ])); err != nil {
+		fmt.Printf("No %s hook installed in %s\n", hookNames[typ], hooksDir())
+		return
+	}
+	stack, err := c.FetchStack()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		failErr(err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
-		os.Exit(1)
+	e := Event{Type: typ, Context: c.context, At: time.Now().UTC()}
+	if e.Context == "" {
+		e.Context = defaultContext
+	}
+	sample := &Task{ID: "000000", Description: "example task", StartedAt: e.At}
+	if top := stack.Peek(); top != nil {
+		sample = top
+	}
+	e.Task, e.Previous, e.Next = sample, sample, sample
+	if len(stack.Tasks) > 1 {
+		e.Next = &stack.Tasks[1]
+	}
+
+	out, err := runHook(hooksDir(), e, []string{"MEMO_HOOK_DRY_RUN=1"})
+	os.Stdout.Write(out)
+	if err != nil {
+		failErr(err)
+	}
+	fmt.Printf("%s ran successfully.\n", hookNames[typ])
+}
+
+type contextInfo struct {
+	Name    string `json:"name"`
+	Current bool   `json:"current"`
+	Tasks   int    `json:"tasks"`
+	Top     *Task  `json:"top,omitempty"`
+}
+
+func (c *memoClient) Contexts() {
+	var contexts []contextInfo
+	if err := c.call("GET", "/contexts", nil, &contexts); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		printJSON(contexts)
+		return
+	}
+
+	for _, ctx := range contexts {
+		marker := "  "
+		if ctx.Current {
+			marker = "* "
+		}
+		line := fmt.Sprintf("%s%s (%s)", marker, ctx.Name, plural(ctx.Tasks, "task"))
+		if ctx.Top != nil {
+			line += ": " + ctx.Top.Description
+		}
+		fmt.Println(line)
+	}
+}
+
+func (c *memoClient) ContextNew(name string) {
+	if err := c.call("POST", "/context/new", map[string]string{"name": name}, nil); err != nil {
+		failErr(err)
+	}
+	if jsonOutput {
+		printJSON(map[string]string{"created": name})
+		return
+	}
+	fmt.Printf("Created context: %s\n", name)
+}
+
+func (c *memoClient) ContextRemove(name string) {
+	if err := c.call("POST", "/context/rm", map[string]string{"name": name}, nil); err != nil {
+		failErr(err)
+	}
+	if jsonOutput {
+		printJSON(map[string]string{"removed": name})
+		return
+	}
+	fmt.Printf("Removed context: %s\n", name)
+}
+
+func (c *memoClient) ContextUse(name string) {
+	var result struct {
+		Context  string `json:"context"`
+		Paused   *Task  `json:"paused,omitempty"`
+		Resuming *Task  `json:"resuming,omitempty"`
+	}
+	if err := c.call("POST", "/context/use", map[string]string{"name": name}, &result); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Context  string      `json:"context"`
+			Paused   *taskOutput `json:"paused"`
+			Resuming *taskOutput `json:"resuming"`
+		}{result.Context, taskJSON(result.Paused, now), taskJSON(result.Resuming, now)})
+		return
+	}
+
+	if result.Paused != nil {
+		fmt.Printf("Paused: %s\n", result.Paused.Label())
+	}
+	fmt.Printf("Context: %s\n", result.Context)
+	if result.Resuming != nil {
+		fmt.Printf("Resuming: %s\n", result.Resuming.Description)
+		printNotes(result.Resuming)
+	}
+}
+
+// Reorder puts the stack's tasks in the order of ids, which were read from
+// the given revision of the stack.
+func (c *memoClient) Reorder(revision int64, ids []string) error {
+	return c.callAt(revision, "POST", "/reorder", map[string][]string{"ids": ids}, nil)
+}
+
+// editResult is the daemon's response to /edit.
+type editResult struct {
+	Task     Task   `json:"task"`
+	Previous string `json:"previous"`
+}
+
+func (c *memoClient) EditTask(id string, position int, description string) (*editResult, error) {
+	var result editResult
+	body := map[string]any{"id": id, "position": position, "description": description}
+	if err := c.call("POST", "/edit", body, &result); err != nil {
+		return nil, err
 	}
+	return &result, nil
+}
 
+func (c *memoClient) FetchStack() (*TaskStack, error) {
+	var stack TaskStack
+	if err := c.call("GET", "/stack", nil, &stack); err != nil {
+		return nil, err
+	}
+	return &stack, nil
+}
+
+func (c *memoClient) fetchLog(filter LogFilter) []LogEntry {
 	var entries []LogEntry
-	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	if err := c.call("GET", "/log?"+filter.Query().Encode(), nil, &entries); err != nil {
+		failErr(err)
 	}
 	return entries
 }
 
-func (c *memoClient) Log() {
-	entries := c.fetchLog()
+func (c *memoClient) Log(filter LogFilter) {
+	entries := c.fetchLog(filter)
+	if jsonOutput {
+		printJSON(logJSON(entries))
+		return
+	}
 	if len(entries) == 0 {
-		fmt.Println("No log entries yet.")
+		if filter.IsZero() {
+			fmt.Println("No log entries yet.")
+		} else {
+			fmt.Println("No matching log entries.")
+		}
 		return
 	}
 	for _, e := range entries {
 		stopped, _ := time.Parse(time.RFC3339, e.Stopped)
-		started, _ := time.Parse(time.RFC3339, e.Started)
-		dur := stopped.Sub(started)
-		fmt.Printf("[%s] %-10s \"%s\" (worked %s)\n",
-			stopped.Local().Format("2006-01-02 15:04"),
+		if e.Reason == "edited" {
+			fmt.Printf("[%s] %-10s \"%s\" (was \"%s\")\n",
+				formatTime(stopped),
+				e.Reason,
+				labelled(e.Task, e.Tags, e.Project),
+				e.Previous)
+			continue
+		}
+		if e.Reason == "break" {
+			fmt.Printf("[%s] %-10s (%s)\n",
+				formatTime(stopped),
+				e.Reason,
+				formatDuration(e.Age()))
+			continue
+		}
+		if e.Reason == "focus" {
+			fmt.Printf("[%s] %-10s \"%s\" (%s)\n",
+				formatTime(stopped),
+				e.Reason,
+				labelled(e.Task, e.Tags, e.Project),
+				formatDuration(e.Age()))
+			continue
+		}
+		if e.Reason == "undone" {
+			fmt.Printf("[%s] %-10s \"%s\" (%s)\n",
+				formatTime(stopped),
+				e.Reason,
+				e.Task,
+				e.Undoes)
+			continue
+		}
+		worked := formatDuration(e.Session())
+		if total := formatDuration(e.ActiveTime()); total != worked {
+			worked += ", " + total + " total"
+		}
+		line := fmt.Sprintf("[%s] %-10s \"%s\" (worked %s)",
+			formatTime(stopped),
 			e.Reason,
-			e.Task,
-			formatDuration(dur))
+			labelled(e.Task, e.Tags, e.Project),
+			worked)
+		if e.ContextName() != defaultContext {
+			line += " [" + e.Context + "]"
+		}
+		fmt.Println(line)
 	}
 }
 
-func (c *memoClient) History() {
-	entries := c.fetchLog()
-	var popped []LogEntry
-	for _, e := range entries {
-		if e.Reason == "popped" {
-			popped = append(popped, e)
-		}
+func (c *memoClient) History(filter LogFilter) {
+	filter.Reasons = []string{"popped"}
+	filter.Effective = true
+	popped := c.fetchLog(filter)
+	if jsonOutput {
+		printJSON(logJSON(popped))
+		return
 	}
 	if len(popped) == 0 {
 		fmt.Println("No completed tasks yet.")
@@ -326,17 +935,81 @@ func (c *memoClient) History() {
 	for _, e := range popped {
 		started, _ := time.Parse(time.RFC3339, e.Started)
 		stopped, _ := time.Parse(time.RFC3339, e.Stopped)
-		dur := stopped.Sub(started)
-		fmt.Printf("%s\n  Started:  %s\n  Finished: %s\n  Duration: %s\n",
-			e.Task,
-			started.Local().Format("2006-01-02 15:04"),
-			stopped.Local().Format("2006-01-02 15:04"),
-			formatDuration(dur))
+		fmt.Printf("%s\n  Started:  %s\n  Finished: %s\n  Duration: %s\n  Active:   %s\n",
+			labelled(e.Task, e.Tags, e.Project),
+			formatTime(started),
+			formatTime(stopped),
+			formatDuration(e.Age()),
+			formatDuration(e.ActiveTime()))
+		if len(e.Notes) > 0 {
+			fmt.Println("  Notes:")
+			for _, n := range e.Notes {
+				fmt.Printf("    [%s] %s\n", formatTime(n.At), n.Text)
+			}
+		}
 	}
 }
 
+// printNotes lists the notes on a task being resumed, so it's clear where
+// work on it left off.
+func printNotes(t *Task) {
+	now := time.Now()
+	for _, n := range t.Notes {
+		at := formatTime(n.At)
+		if startOfDay(n.At.Local()).Equal(startOfDay(now)) {
+			at = formatClock(n.At)
+		}
+		fmt.Printf("  [%s] %s\n", at, n.Text)
+	}
+}
+
+// Report prints the time spent between filter.Since and filter.Until,
+// grouped as buildReport describes.
+func (c *memoClient) Report(filter LogFilter, by string, markdown bool) {
+	// Sessions that end after Until still count up to it, so the server only
+	// filters on Since and the report clips the rest.
+	since, until := filter.Since, filter.Until
+	filter.Until = time.Time{}
+	filter.Effective = true
+	report := buildReport(c.fetchLog(filter), by, since, until)
+	if jsonOutput {
+		printJSON(reportJSON(report))
+		return
+	}
+	if len(report.Rows) == 0 {
+		fmt.Println("No time logged in this period.")
+		return
+	}
+	fmt.Print(report.Text(markdown))
+}
+
+// workingFor describes the time spent on a running task, adding its
+// wall-clock age when the task has spent a while paused.
+func workingFor(t Task, now time.Time) string {
+	active := t.Active(now)
+	age := now.Sub(t.StartedAt)
+	if age-active < time.Minute {
+		return fmt.Sprintf("working for %s", formatDuration(active))
+	}
+	return fmt.Sprintf("working for %s, started %s ago", formatDuration(active), formatDuration(age))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// formatDuration formats d in the configured duration style.
 func formatDuration(d time.Duration) string {
 	d = d.Round(time.Second)
+	switch config.Load().DurationStyle {
+	case "clock":
+		return fmt.Sprintf("%d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	case "decimal":
+		return strconv.FormatFloat(d.Hours(), 'f', 2, 64) + "h"
+	}
 	if d < time.Minute {
 		return fmt.Sprintf("%ds", int(d.Seconds()))
 	}
@@ -350,3 +1023,13 @@ func formatDuration(d time.Duration) string {
 	}
 	return fmt.Sprintf("%dh%dm", h, m)
 }
+
+// formatTime formats t as a local date and time in the configured layout.
+func formatTime(t time.Time) string {
+	return t.Local().Format(config.Load().DateTimeFormat)
+}
+
+// formatClock formats t as a local time of day in the configured layout.
+func formatClock(t time.Time) string {
+	return t.Local().Format(config.Load().TimeFormat)
+}
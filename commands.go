@@ -0,0 +1,448 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// commands is memo's command tree, in the order memo help lists it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			Name:    "stack",
+			Summary: "Interactive stack workspace (or show the stack if non-interactive)",
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				filter := taskFilterFlags(fs)
+				return func([]string) {
+					c := connectClient()
+					if !jsonOutput && term.IsTerminal(int(os.Stdout.Fd())) {
+						runTUI(c, filter())
+					} else {
+						c.Stack(filter())
+					}
+				}
+			},
+		},
+		{
+			Name:    "push",
+			Usage:   "<description>",
+			Summary: "Push a new task onto the stack",
+			Help: `The current task is paused. Descriptions may include +tags and an @project.
+With --after, the task is added paused right below the nth task from the top
+or the task with the given ID instead.`,
+			Text:    true,
+			MinArgs: 1, MaxArgs: -1,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				tags, project := tagFlags(fs)
+				note := fs.String("note", "", "note `text` to add to the task being paused")
+				after := fs.String("after", "", "add the task below this `position or id` instead")
+				return func(args []string) {
+					description := strings.Join(args, " ")
+					if *after != "" {
+						// --after n puts the task at position n+1; anything
+						// else names a task. IDs may be all digits, so they
+						// win over positions.
+						id, position := *after, 0
+						if n, err := strconv.Atoi(id); err == nil && n >= 0 && !taskID.MatchString(id) {
+							id, position = "", n+1
+						}
+						connectClient().Insert(description, *tags, *project, position, id, *note)
+						return
+					}
+					connectClient().Push(description, *tags, *project, *note)
+				}
+			},
+		},
+		{
+			Name:    "insert",
+			Usage:   "<position> <description>",
+			Summary: "Add a paused task at a position from the top (1 starts it)",
+			Text:    true,
+			MinArgs: 2, MaxArgs: -1,
+			Complete: []string{"position"},
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				tags, project := tagFlags(fs)
+				note := fs.String("note", "", "note `text` to add to the task being paused")
+				return func(args []string) {
+					position, err := strconv.Atoi(args[0])
+					if err != nil || position < 1 {
+						failUsage(fmt.Sprintf("memo insert: invalid position %q", args[0]))
+					}
+					// Flags may also come after the position.
+					rest := parseArgs(fs, args[1:], true)
+					if len(rest) < 1 {
+						usageError(lookupCommand("insert"), "missing description")
+					}
+					connectClient().Insert(strings.Join(rest, " "), *tags, *project, position, "", *note)
+				}
+			},
+		},
+		{
+			Name:    "queue",
+			Usage:   "<description>",
+			Summary: "Add a task to the bottom of the stack",
+			Text:    true,
+			MinArgs: 1, MaxArgs: -1,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				tags, project := tagFlags(fs)
+				return func(args []string) {
+					connectClient().Queue(strings.Join(args, " "), *tags, *project)
+				}
+			},
+		},
+		{
+			Name:    "import",
+			Usage:   "<file>",
+			Summary: "Add the tasks from a todo.txt, Taskwarrior or plain-text list",
+			Help: `Reads the file, or standard input if it's "-", in one of these formats:
+
+  todotxt           todo.txt; (A) becomes the tag pri-a, the first +project
+                    the project, and @contexts and other +projects tags
+  taskwarrior-json  the output of task export; priorities H, M and L become
+                    the tags pri-h, pri-m and pri-l
+  lines             a task per line, written as for memo push
+
+Completed tasks are left out and the rest are added most urgent first, at the
+bottom of the stack or, with --push, on top. Tasks with the same description
+as one already on the stack are skipped, as are repeats within the list. The
+import is a single change, so memo undo takes it back.`,
+			MinArgs: 1, MaxArgs: 1,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				format := fs.String("format", "lines", "`format` to read: "+strings.Join(importFormats, ", "))
+				queue := fs.Bool("queue", false, "add the tasks at the bottom of the stack (the default)")
+				push := fs.Bool("push", false, "add the tasks on top of the stack")
+				return func(args []string) {
+					if !slices.Contains(importFormats, *format) {
+						failUsage(fmt.Sprintf("memo import: --format must be one of %s", strings.Join(importFormats, ", ")))
+					}
+					if *queue && *push {
+						failUsage("memo import: --queue and --push can't be used together")
+					}
+					connectClient().Import(args[0], *format, *push)
+				}
+			},
+		},
+		{
+			Name:    "edit",
+			Usage:   "[<position|id>] <description>",
+			Summary: "Change the description of a task",
+			Help:    "Edits the current task, the nth task from the top or the task with the given ID.",
+			Text:    true,
+			MinArgs: 1, MaxArgs: -1,
+			Complete: []string{"position"},
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				return func(args []string) {
+					id, position, rest := editTarget(args)
+					connectClient().Edit(id, position, strings.Join(rest, " "))
+				}
+			},
+		},
+		{
+			Name:    "note",
+			Usage:   "<text>",
+			Summary: "Add a note to the current task",
+			Text:    true,
+			MinArgs: 1, MaxArgs: -1,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				id := fs.String("id", "", "add the note to the task with this `id` instead")
+				return func(args []string) {
+					connectClient().Note(*id, strings.Join(args, " "))
+				}
+			},
+		},
+		{
+			Name:    "pop",
+			Summary: "Pop the current task off the stack",
+			Setup:   client(func(c *memoClient, _ []string) { c.Pop("") }),
+		},
+		{
+			Name:    "done",
+			Usage:   "<id>",
+			Summary: "Complete the task with the given ID",
+			MinArgs: 1, MaxArgs: 1,
+			Complete: []string{"task"},
+			Setup:    client(func(c *memoClient, args []string) { c.Pop(args[0]) }),
+		},
+		{
+			Name:     "drop",
+			Usage:    "[<id>]",
+			Summary:  "Drop the current (or given) task without completing it",
+			MaxArgs:  1,
+			Complete: []string{"task"},
+			Setup:    client(func(c *memoClient, args []string) { c.Drop(optionalArg(args)) }),
+		},
+		{
+			Name:    "resume",
+			Usage:   "<id>",
+			Summary: "Move the task with the given ID to the top",
+			MinArgs: 1, MaxArgs: 1,
+			Complete: []string{"task"},
+			Setup:    client(func(c *memoClient, args []string) { c.Resume(args[0]) }),
+		},
+		{
+			Name:    "switch",
+			Summary: "Swap the top two tasks",
+			Setup:   client(func(c *memoClient, _ []string) { c.Switch() }),
+		},
+		{
+			Name:    "undo",
+			Summary: "Undo the last change to the stack",
+			Setup:   client(func(c *memoClient, _ []string) { c.Undo() }),
+		},
+		{
+			Name:    "redo",
+			Summary: "Redo the last undone change",
+			Setup:   client(func(c *memoClient, _ []string) { c.Redo() }),
+		},
+		{
+			Name:     "focus",
+			Usage:    "[<length>]",
+			Summary:  "Start a focus session on the current task (default 25m)",
+			Help:     "The length is a number of minutes or a duration like 25m.",
+			MaxArgs:  1,
+			Complete: []string{"text"},
+			Setup: client(func(c *memoClient, args []string) {
+				c.Focus(focusLength(args))
+			}),
+			Commands: []*command{
+				{Name: "stop", Summary: "Cancel the focus session", Setup: client(func(c *memoClient, _ []string) { c.StopFocus() })},
+			},
+		},
+		{
+			Name:     "break",
+			Usage:    "[<length>]",
+			Summary:  "Pause for a break (default 5m)",
+			Help:     "The length is a number of minutes or a duration like 5m.",
+			MaxArgs:  1,
+			Complete: []string{"text"},
+			Setup: client(func(c *memoClient, args []string) {
+				c.Break(focusLength(args))
+			}),
+			Commands: []*command{
+				{Name: "end", Summary: "End the break early", Setup: client(func(c *memoClient, _ []string) { c.EndBreak() })},
+			},
+		},
+		{
+			Name:    "idle",
+			Summary: "Pause the current task while you're away",
+			Setup:   client(func(c *memoClient, _ []string) { c.Idle() }),
+		},
+		{
+			Name:    "back",
+			Summary: "Resume after being away",
+			Help:    "Without a command, the time away is dealt with as idle.return says.",
+			Setup:   client(func(c *memoClient, _ []string) { c.Back("", "") }),
+			Commands: []*command{
+				{Name: "keep", Summary: "Count the time away on the task", Setup: client(func(c *memoClient, _ []string) { c.Back("keep", "") })},
+				{Name: "discard", Summary: "Drop the time away", Setup: client(func(c *memoClient, _ []string) { c.Back("discard", "") })},
+				{Name: "reassign", Usage: "<id>", Summary: "Give the time away to another task", MinArgs: 1, MaxArgs: 1, Complete: []string{"task"},
+					Setup: client(func(c *memoClient, args []string) { c.Back("reassign", args[0]) })},
+			},
+		},
+		{
+			Name:    "log",
+			Summary: "Show all task activity",
+			Help:    timeArgsHelp,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				filter := logFlags(fs)
+				return func([]string) { connectClient().Log(filter()) }
+			},
+		},
+		{
+			Name:    "history",
+			Summary: "Show completed tasks with durations",
+			Help:    timeArgsHelp,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				filter := logFlags(fs)
+				return func([]string) { connectClient().History(filter()) }
+			},
+		},
+		{
+			Name:    "report",
+			Summary: "Total time by task, context, tag, project, day or week",
+			Help:    timeArgsHelp,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				filter := logFlags(fs)
+				by := fs.String("by", "task", "`group` time by task, context, tag, project, day or week")
+				markdown := fs.Bool("markdown", false, "print a Markdown table")
+				return func([]string) {
+					if !validReportGroup(*by) {
+						failUsage(fmt.Sprintf("memo report: --by must be one of %s", strings.Join(reportGroups, ", ")))
+					}
+					connectClient().Report(filter(), *by, *markdown)
+				}
+			},
+		},
+		{
+			Name:    "export",
+			Summary: "Export work sessions as CSV, iCalendar, JSON or a Markdown timesheet",
+			Help: timeArgsHelp + `
+
+Each session is a stretch of work on a task, clipped to --since and --until.
+CSV, JSON and Markdown show times in the local time zone (set TZ to change
+it); iCalendar events are in UTC.`,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				filter := logFlags(fs)
+				format := fs.String("format", "csv", "`format` to write: "+strings.Join(exportFormats, ", "))
+				return func([]string) {
+					if !slices.Contains(exportFormats, *format) {
+						failUsage(fmt.Sprintf("memo export: --format must be one of %s", strings.Join(exportFormats, ", ")))
+					}
+					connectClient().Export(filter(), *format)
+				}
+			},
+		},
+		{
+			Name:    "watch",
+			Summary: "Print stack changes as they happen",
+			Setup:   client(func(c *memoClient, _ []string) { c.Watch() }),
+		},
+		{
+			Name:    "status",
+			Summary: "One-line status for tmux, prompts and bars",
+			Help: `Prints nothing if the daemon isn't running. The format is a preset (` + strings.Join(statusPresetNames(), ", ") + `)
+or a Go template; see the README for its fields.`,
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				format := fs.String("format", "plain", "`preset` or Go template")
+				return func([]string) { runStatus(*format) }
+			},
+		},
+		{
+			Name:    "hooks",
+			Summary: "List or test hook scripts",
+			Setup:   func(*flag.FlagSet) func([]string) { return func([]string) { listHooks() } },
+			Commands: []*command{
+				{Name: "list", Summary: "List hook scripts", Setup: func(*flag.FlagSet) func([]string) { return func([]string) { listHooks() } }},
+				{Name: "test", Usage: "<event>", Summary: "Run a hook against the current task", MinArgs: 1, MaxArgs: 1, Complete: []string{"event"},
+					Setup: client(func(c *memoClient, args []string) { c.TestHook(args[0]) })},
+			},
+		},
+		{
+			Name:    "context",
+			Summary: "List, create, switch or remove contexts",
+			Setup:   client(func(c *memoClient, _ []string) { c.Contexts() }),
+			Commands: []*command{
+				{Name: "list", Summary: "List contexts", Setup: client(func(c *memoClient, _ []string) { c.Contexts() })},
+				{Name: "new", Usage: "<name>", Summary: "Create a context", MinArgs: 1, MaxArgs: 1, Complete: []string{"text"},
+					Setup: client(func(c *memoClient, args []string) { c.ContextNew(args[0]) })},
+				{Name: "use", Usage: "<name>", Summary: "Switch to a context", MinArgs: 1, MaxArgs: 1, Complete: []string{"context"},
+					Setup: client(func(c *memoClient, args []string) { c.ContextUse(args[0]) })},
+				{Name: "rm", Usage: "<name>", Summary: "Remove an empty context", MinArgs: 1, MaxArgs: 1, Complete: []string{"context"},
+					Setup: client(func(c *memoClient, args []string) { c.ContextRemove(args[0]) })},
+			},
+		},
+		{
+			Name:    "config",
+			Summary: "Show or change settings",
+			Help: `The config file is config.toml in $MEMO_HOME, $XDG_CONFIG_HOME/memo or
+~/.memo. Data lives in $MEMO_HOME, $XDG_STATE_HOME/memo or ~/.memo unless
+data_dir says otherwise; data already in ~/.memo stays there. MEMO_IDLE_AFTER,
+MEMO_IDLE_RETURN and MEMO_IDLE_PROBE override the idle settings.`,
+			Setup: func(*flag.FlagSet) func([]string) { return func([]string) { listConfig() } },
+			Commands: []*command{
+				{Name: "list", Summary: "Show all settings", Setup: func(*flag.FlagSet) func([]string) { return func([]string) { listConfig() } }},
+				{Name: "get", Usage: "<key>", Summary: "Show one setting", MinArgs: 1, MaxArgs: 1, Complete: []string{"setting"},
+					Setup: func(*flag.FlagSet) func([]string) { return func(args []string) { getConfig(args[0]) } }},
+				{Name: "set", Usage: "<key> <value>", Summary: "Change a setting in config.toml", MinArgs: 2, MaxArgs: 2, Complete: []string{"setting"},
+					Setup: func(*flag.FlagSet) func([]string) { return func(args []string) { setConfig(args[0], args[1]) } }},
+			},
+		},
+		{
+			Name:    "migrate",
+			Summary: "Copy the data to another storage backend and switch to it",
+			Setup: func(fs *flag.FlagSet) func([]string) {
+				to := fs.String("to", "", "`backend` to move the data to: "+strings.Join(storageBackends, " or "))
+				return func([]string) {
+					if *to == "" {
+						usageError(lookupCommand("migrate"), "--to is required")
+					}
+					runMigrate(*to)
+				}
+			},
+		},
+		{
+			Name:    "completion",
+			Usage:   "bash|zsh|fish",
+			Summary: "Print a shell completion script",
+			MinArgs: 1, MaxArgs: 1,
+			Complete: []string{"bash zsh fish"},
+			Setup:    func(*flag.FlagSet) func([]string) { return func(args []string) { runCompletion(args[0]) } },
+		},
+		{
+			Name:    "man",
+			Summary: "Print the man page",
+			Setup:   func(*flag.FlagSet) func([]string) { return func([]string) { writeManPage(os.Stdout) } },
+		},
+		{
+			Name:     "help",
+			Usage:    "[<command>]",
+			Summary:  "Show help for memo or a command",
+			MaxArgs:  -1,
+			Complete: []string{"command"},
+			Setup:    func(*flag.FlagSet) func([]string) { return runHelp },
+		},
+		{
+			Name:   "__complete",
+			Raw:    true,
+			Hidden: true,
+			Setup:  func(*flag.FlagSet) func([]string) { return runComplete },
+		},
+		{
+			Name:   "__daemon",
+			Raw:    true,
+			Hidden: true,
+			Setup:  func(*flag.FlagSet) func([]string) { return func([]string) { runDaemon() } },
+		},
+	}
+	linkCommands(nil, commands)
+}
+
+const timeArgsHelp = `Times may be dates (2026-02-20), dates and times (2026-02-20 14:30), or
+durations ago (90m, 3d, 2w). With --context, only that context's entries are
+included.`
+
+// client makes a Setup for a command without flags of its own that talks to
+// the daemon.
+func client(run func(c *memoClient, args []string)) func(*flag.FlagSet) func([]string) {
+	return func(*flag.FlagSet) func([]string) {
+		return func(args []string) { run(connectClient(), args) }
+	}
+}
+
+// tagFlags registers --tag and --project for commands that add tasks.
+func tagFlags(fs *flag.FlagSet) (*[]string, *string) {
+	var tags stringList
+	fs.Var(&tags, "tag", "add this `tag` (may be repeated)")
+	project := fs.String("project", "", "put the task in this `project`")
+	return (*[]string)(&tags), project
+}
+
+// taskFilterFlags registers --tag and --project for commands that show the
+// stack.
+func taskFilterFlags(fs *flag.FlagSet) func() taskFilter {
+	var tags stringList
+	fs.Var(&tags, "tag", "only tasks with this `tag` (may be repeated)")
+	project := fs.String("project", "", "only tasks in this `project`")
+	return func() taskFilter {
+		return taskFilter{Tags: addTags(nil, tags...), Project: strings.TrimPrefix(*project, "@")}
+	}
+}
+
+// focusLength checks the optional length given to focus or break.
+func focusLength(args []string) string {
+	length := optionalArg(args)
+	if length != "" {
+		if _, err := parseFocusLength(length); err != nil {
+			failUsage(err.Error())
+		}
+	}
+	return length
+}
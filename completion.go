@@ -0,0 +1,295 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// flagKinds says what the values of flags are, for completion: see
+// kindCandidates. Other flags take text.
+var flagKinds = map[string]string{
+	"tag":     "tag",
+	"project": "project",
+	"context": "context",
+	"id":      "task",
+	"after":   "position",
+	"by":      strings.Join(reportGroups, " "),
+	"to":      strings.Join(storageBackends, " "),
+	"format":  strings.Join(statusPresetNames(), " "),
+}
+
+// commandFlagKinds overrides flagKinds for one command's flag.
+var commandFlagKinds = map[string]string{
+	"export --format": strings.Join(exportFormats, " "),
+	"import --format": strings.Join(importFormats, " "),
+}
+
+// candidate is a completion with an optional description.
+type candidate struct {
+	Value, Help string
+}
+
+// complete returns the completions for the last of words, the arguments
+// typed after memo so far.
+func complete(words []string, daemon *memoClient) []candidate {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	words = words[:len(words)-1]
+
+	// Walk down the command tree, noting flags and arguments on the way.
+	root := &command{Commands: commands}
+	cmd := root
+	fs, _ := cmd.setup()
+	var positional []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if word == "--" {
+			positional = append(positional, words[i+1:]...)
+			break
+		}
+		if name, ok := strings.CutPrefix(word, "--"); ok && !strings.Contains(name, "=") {
+			if f := fs.Lookup(name); f != nil && !isBoolFlag(f) {
+				if name == "context" && i+1 < len(words) {
+					selectedContext = words[i+1]
+				}
+				if i == len(words)-1 {
+					return filterCandidates(kindCandidates(flagKind(cmd, name), daemon), current)
+				}
+				i++
+			}
+			continue
+		}
+		if len(positional) == 0 {
+			if sub := findCommand(cmd.Commands, word); sub != nil && !sub.Hidden {
+				cmd = sub
+				fs, _ = cmd.setup()
+				continue
+			}
+		}
+		positional = append(positional, word)
+	}
+	if daemon != nil {
+		daemon.context = selectedContext
+	}
+
+	var out []candidate
+	switch {
+	case strings.HasPrefix(current, "-"):
+		fs.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			out = append(out, candidate{"--" + f.Name, usage})
+		})
+	case strings.HasPrefix(current, "+") && cmd.Text && fs.Lookup("tag") != nil:
+		out = prefixCandidates("+", kindCandidates("tag", daemon))
+	case strings.HasPrefix(current, "@") && cmd.Text && fs.Lookup("project") != nil:
+		out = prefixCandidates("@", kindCandidates("project", daemon))
+	default:
+		if len(positional) == 0 {
+			for _, c := range cmd.Commands {
+				if !c.Hidden {
+					out = append(out, candidate{c.Name, c.Summary})
+				}
+			}
+		}
+		if len(positional) < len(cmd.Complete) {
+			out = append(out, kindCandidates(cmd.Complete[len(positional)], daemon)...)
+		}
+	}
+	return filterCandidates(out, current)
+}
+
+func flagKind(cmd *command, name string) string {
+	if kind, ok := commandFlagKinds[cmd.path()+" --"+name]; ok {
+		return kind
+	}
+	if kind, ok := flagKinds[name]; ok {
+		return kind
+	}
+	return "text"
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func prefixCandidates(prefix string, cs []candidate) []candidate {
+	for i := range cs {
+		cs[i].Value = prefix + cs[i].Value
+	}
+	return cs
+}
+
+func filterCandidates(cs []candidate, prefix string) []candidate {
+	return slices.DeleteFunc(cs, func(c candidate) bool { return !strings.HasPrefix(c.Value, prefix) })
+}
+
+// kindCandidates lists the values of a kind of argument. Tasks, tags,
+// projects and contexts come from the daemon, if it's running.
+func kindCandidates(kind string, daemon *memoClient) []candidate {
+	var out []candidate
+	switch kind {
+	case "text":
+	case "task", "position", "tag", "project":
+		if daemon == nil {
+			return nil
+		}
+		stack, err := daemon.FetchStack()
+		if err != nil {
+			return nil
+		}
+		var tags, projects []string
+		for i, t := range stack.List() {
+			switch kind {
+			case "task":
+				out = append(out, candidate{t.ID, t.Label()})
+			case "position":
+				out = append(out, candidate{strconv.Itoa(i + 1), t.Label()})
+			}
+			tags = addTags(tags, t.Tags...)
+			if t.Project != "" && !slices.Contains(projects, t.Project) {
+				projects = append(projects, t.Project)
+			}
+		}
+		if kind == "position" {
+			for _, t := range stack.List() {
+				out = append(out, candidate{t.ID, t.Label()})
+			}
+		}
+		slices.Sort(tags)
+		slices.Sort(projects)
+		switch kind {
+		case "tag":
+			for _, tag := range tags {
+				out = append(out, candidate{Value: tag})
+			}
+		case "project":
+			for _, p := range projects {
+				out = append(out, candidate{Value: p})
+			}
+		}
+	case "context":
+		if daemon == nil {
+			return nil
+		}
+		var contexts []struct {
+			Name  string `json:"name"`
+			Tasks int    `json:"tasks"`
+		}
+		if err := daemon.call("GET", "/contexts", nil, &contexts); err != nil {
+			return nil
+		}
+		for _, c := range contexts {
+			out = append(out, candidate{c.Name, plural(c.Tasks, "task")})
+		}
+	case "setting":
+		for _, s := range configSettings {
+			out = append(out, candidate{s.Key, s.Usage})
+		}
+	case "event":
+		for typ, hook := range hookNames {
+			out = append(out, candidate{typ, hook})
+		}
+		slices.SortFunc(out, func(a, b candidate) int { return strings.Compare(a.Value, b.Value) })
+	case "command":
+		for _, c := range commands {
+			if !c.Hidden {
+				out = append(out, candidate{c.Name, c.Summary})
+			}
+		}
+	default:
+		for _, word := range strings.Fields(kind) {
+			out = append(out, candidate{Value: word})
+		}
+	}
+	return out
+}
+
+// runComplete prints completions for the shell scripts, one per line with
+// a tab before any description. Like memo status, it doesn't start the
+// daemon; without one, only what memo knows itself is completed.
+func runComplete(words []string) {
+	var daemon *memoClient
+	if tryConnect(socketPath()) {
+		daemon = newClient()
+	}
+	for _, c := range complete(words, daemon) {
+		if c.Help != "" {
+			fmt.Printf("%s\t%s\n", c.Value, c.Help)
+		} else {
+			fmt.Println(c.Value)
+		}
+	}
+}
+
+// When memo has nothing to offer, each script falls back to completing
+// file names.
+const bashCompletion = `# bash completion for memo; load with: source <(memo completion bash)
+_memo() {
+	local IFS=$'\n'
+	COMPREPLY=($(memo __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -o default -F _memo memo
+`
+
+const zshCompletion = `#compdef memo
+# zsh completion for memo; load with: source <(memo completion zsh)
+# or save it as _memo in a directory on $fpath.
+compdef _memo memo
+
+_memo() {
+	local -a lines described
+	local line value
+	lines=("${(@f)$(memo __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	for line in ${lines:#}; do
+		value=${line%%$'\t'*}
+		if [[ $line == *$'\t'* ]]; then
+			described+=("${value//:/\\:}:${line#*$'\t'}")
+		else
+			described+=("${value//:/\\:}")
+		fi
+	done
+	if (( ${#described} )); then
+		_describe -V memo described
+	else
+		_files
+	fi
+}
+
+if [ "$funcstack[1]" = "_memo" ]; then
+	_memo "$@"
+fi
+`
+
+const fishCompletion = `# fish completion for memo; load with: memo completion fish | source
+# or save it as ~/.config/fish/completions/memo.fish.
+function __memo_complete
+	set -l out (memo __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+	if test (count $out) -eq 0
+		__fish_complete_path (commandline -ct)
+	else
+		printf '%s\n' $out
+	end
+end
+
+complete -c memo -f -a '(__memo_complete)'
+`
+
+func runCompletion(shell string) {
+	switch shell {
+	case "bash":
+		os.Stdout.WriteString(bashCompletion)
+	case "zsh":
+		os.Stdout.WriteString(zshCompletion)
+	case "fish":
+		os.Stdout.WriteString(fishCompletion)
+	default:
+		failUsage(fmt.Sprintf("memo completion: unknown shell %q (bash, zsh or fish)", shell))
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config is memo's configuration, read from config.toml. Every setting has a
+// default, so the file is optional.
+type Config struct {
+	DataDir        string
+	Storage        string
+	Socket         string
+	DateTimeFormat string
+	TimeFormat     string
+	DurationStyle  string
+	Output         string
+	IdleAfter      time.Duration
+	IdleReturn     string
+	IdleProbe      string
+	HooksDir       string
+	HookTimeout    time.Duration
+}
+
+// configSetting describes one key in config.toml.
+type configSetting struct {
+	Key   string
+	Usage string
+	get   func(*Config) string
+	set   func(*Config, string) error
+}
+
+var durationStyles = []string{"short", "clock", "decimal"}
+
+var configSettings = []configSetting{
+	{"data_dir", "directory for state, logs and hooks",
+		func(c *Config) string { return c.DataDir },
+		func(c *Config, v string) error { c.DataDir = expandHome(v); return nil }},
+	{"storage", "storage backend: json or sqlite (see memo migrate)",
+		func(c *Config) string { return c.Storage },
+		func(c *Config, v string) error { return setChoice(&c.Storage, v, storageBackends) }},
+	{"socket", "daemon socket path (default: memo.sock in data_dir)",
+		func(c *Config) string { return c.Socket },
+		func(c *Config, v string) error { c.Socket = expandHome(v); return nil }},
+	{"output", "default output format: text or json",
+		func(c *Config) string { return c.Output },
+		func(c *Config, v string) error { return setChoice(&c.Output, v, []string{"text", "json"}) }},
+	{"format.datetime", "Go time layout for dates and times",
+		func(c *Config) string { return c.DateTimeFormat },
+		func(c *Config, v string) error { return setLayout(&c.DateTimeFormat, v) }},
+	{"format.time", "Go time layout for times of day",
+		func(c *Config) string { return c.TimeFormat },
+		func(c *Config, v string) error { return setLayout(&c.TimeFormat, v) }},
+	{"format.duration", "duration style: short (1h5m), clock (1:05) or decimal (1.08h)",
+		func(c *Config) string { return c.DurationStyle },
+		func(c *Config, v string) error { return setChoice(&c.DurationStyle, v, durationStyles) }},
+	{"idle.after", "pause the current task after this long idle; 0 turns it off",
+		func(c *Config) string { return c.IdleAfter.String() },
+		func(c *Config, v string) error { return setDuration(&c.IdleAfter, v) }},
+	{"idle.return", "on coming back: ask, keep or discard the time away",
+		func(c *Config) string { return c.IdleReturn },
+		func(c *Config, v string) error { return setChoice(&c.IdleReturn, v, idleReturns) }},
+	{"idle.probe", "command printing the idle time in ms, or none (default: xprintidle under X11)",
+		func(c *Config) string { return c.IdleProbe },
+		func(c *Config, v string) error { c.IdleProbe = v; return nil }},
+	{"hooks.dir", "directory of hook scripts (default: hooks in data_dir)",
+		func(c *Config) string { return c.HooksDir },
+		func(c *Config, v string) error { c.HooksDir = expandHome(v); return nil }},
+	{"hooks.timeout", "how long a hook may run before it's killed",
+		func(c *Config) string { return c.HookTimeout.String() },
+		func(c *Config, v string) error { return setDuration(&c.HookTimeout, v) }},
+}
+
+func findSetting(key string) *configSetting {
+	for i := range configSettings {
+		if configSettings[i].Key == key {
+			return &configSettings[i]
+		}
+	}
+	return nil
+}
+
+func setChoice(field *string, v string, choices []string) error {
+	if !slices.Contains(choices, v) {
+		return fmt.Errorf("must be one of %s", strings.Join(choices, ", "))
+	}
+	*field = v
+	return nil
+}
+
+func setLayout(field *string, v string) error {
+	if strings.TrimSpace(v) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	*field = v
+	return nil
+}
+
+func setDuration(field *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fmt.Errorf("must be a duration like 10m")
+	}
+	*field = d
+	return nil
+}
+
+// defaultConfig returns the configuration used when config.toml doesn't say
+// otherwise.
+func defaultConfig() *Config {
+	return &Config{
+		DataDir:        defaultDataDir(),
+		Storage:        "json",
+		DateTimeFormat: "2006-01-02 15:04",
+		TimeFormat:     "15:04",
+		DurationStyle:  "short",
+		Output:         "text",
+		IdleReturn:     "ask",
+		HookTimeout:    10 * time.Second,
+	}
+}
+
+// defaultDataDir is $MEMO_HOME, or memo in $XDG_STATE_HOME if that is set,
+// or ~/.memo. Data from before memo knew about XDG_STATE_HOME stays where it
+// is: ~/.memo is kept if it has any.
+func defaultDataDir() string {
+	if dir := os.Getenv("MEMO_HOME"); dir != "" {
+		return dir
+	}
+	legacy := filepath.Join(userHome(), ".memo")
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" && !hasData(legacy) {
+		return filepath.Join(dir, "memo")
+	}
+	return legacy
+}
+
+// hasData reports whether dir holds memo's data in either backend.
+func hasData(dir string) bool {
+	for _, name := range []string{"state.json", "log.jsonl", "memo.db"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// configPath is config.toml in $MEMO_HOME, or in memo in $XDG_CONFIG_HOME if
+// that is set, or in ~/.memo.
+func configPath() string {
+	if dir := os.Getenv("MEMO_HOME"); dir != "" {
+		return filepath.Join(dir, "config.toml")
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "memo", "config.toml")
+	}
+	return filepath.Join(userHome(), ".memo", "config.toml")
+}
+
+func userHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	return home
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(userHome(), path[1:])
+	}
+	return path
+}
+
+// LoadConfig reads the config file at path, if there is one, over the
+// defaults.
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, v := range values {
+		setting := findSetting(v.key)
+		if setting == nil {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", path, v.line, v.key)
+		}
+		if err := setting.set(cfg, v.value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s %v", path, v.line, v.key, err)
+		}
+	}
+	return cfg, nil
+}
+
+// config holds the configuration in use. The daemon swaps it on SIGHUP.
+var config atomic.Pointer[Config]
+
+func init() {
+	config.Store(defaultConfig())
+}
+
+// loadConfig reads config.toml into config.
+func loadConfig() error {
+	cfg, err := LoadConfig(configPath())
+	if err != nil {
+		return err
+	}
+	config.Store(cfg)
+	return nil
+}
+
+// tomlValue is a key set in a TOML file, named with its table, as in
+// idle.after.
+type tomlValue struct {
+	key   string
+	value string
+	line  int
+}
+
+// parseTOML reads the subset of TOML config.toml uses: tables, and keys set
+// to strings, numbers or booleans. Values are returned as text.
+func parseTOML(text string) ([]tomlValue, error) {
+	var values []tomlValue
+	table := ""
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 || strings.TrimSpace(stripComment(line[end+1:])) != "" {
+				return nil, fmt.Errorf("line %d: invalid table header", i+1)
+			}
+			table = strings.TrimSpace(line[1:end])
+			continue
+		}
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		values = append(values, tomlValue{key, value, i + 1})
+	}
+	return values, nil
+}
+
+func parseTOMLValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; c {
+			case '"':
+				if strings.TrimSpace(stripComment(s[i+1:])) != "" {
+					return "", fmt.Errorf("unexpected text after string")
+				}
+				return b.String(), nil
+			case '\\':
+				i++
+				if i == len(s) {
+					return "", fmt.Errorf("unterminated string")
+				}
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\':
+					b.WriteByte(s[i])
+				default:
+					return "", fmt.Errorf("unknown escape \\%c", s[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if strings.TrimSpace(stripComment(s[end+2:])) != "" {
+			return "", fmt.Errorf("unexpected text after string")
+		}
+		return s[1 : end+1], nil
+	}
+	s = strings.TrimSpace(stripComment(s))
+	if s == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil && s != "true" && s != "false" {
+		return "", fmt.Errorf("invalid value %q (quote strings)", s)
+	}
+	return s, nil
+}
+
+func stripComment(s string) string {
+	if i := strings.Index(s, "#"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// SetConfigValue sets key to value in the config file at path, keeping the
+// rest of the file as it is.
+func SetConfigValue(path, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	table, name := "", key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		table, name = key[:i], key[i+1:]
+	}
+	assignment := name + " = " + strconv.Quote(value)
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	current := ""
+	insertAt := -1 // after the last line of the table, if it's found
+	if table == "" {
+		insertAt = 0
+	}
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.Index(trimmed, "]"); end > 0 {
+				current = strings.TrimSpace(trimmed[1:end])
+			}
+			if current == table {
+				insertAt = i + 1
+			}
+			continue
+		}
+		if current != table {
+			continue
+		}
+		if k, _, ok := strings.Cut(trimmed, "="); ok && !strings.HasPrefix(trimmed, "#") {
+			if strings.TrimSpace(k) == name {
+				lines[i] = assignment
+				return writeConfig(path, lines)
+			}
+			insertAt = i + 1
+		}
+	}
+	if insertAt < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+table+"]", assignment)
+	} else {
+		lines = slices.Insert(lines, insertAt, assignment)
+	}
+	return writeConfig(path, lines)
+}
+
+func writeConfig(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func getConfig(key string) {
+	setting := findSetting(key)
+	if setting == nil {
+		failUsage(fmt.Sprintf("Unknown setting: %s", key))
+	}
+	value := setting.get(config.Load())
+	if jsonOutput {
+		printJSON(map[string]string{setting.Key: value})
+		return
+	}
+	fmt.Println(value)
+}
+
+func listConfig() {
+	cfg := config.Load()
+	if jsonOutput {
+		values := map[string]string{}
+		for _, s := range configSettings {
+			values[s.Key] = s.get(cfg)
+		}
+		printJSON(values)
+		return
+	}
+	fmt.Printf("# %s\n", configPath())
+	for _, s := range configSettings {
+		fmt.Printf("%-16s %-20s # %s\n", s.Key, strconv.Quote(s.get(cfg)), s.Usage)
+	}
+}
+
+// setConfig checks and saves a setting, and has the daemon pick it up: a
+// reload for most settings, or a restart on next use for the data directory,
+// storage and socket, which it can't switch while running.
+func setConfig(key, value string) {
+	setting := findSetting(key)
+	if setting == nil {
+		failUsage(fmt.Sprintf("Unknown setting: %s", key))
+	}
+	cfg := *config.Load()
+	if err := setting.set(&cfg, value); err != nil {
+		failUsage(fmt.Sprintf("Invalid %s: %v", key, err))
+	}
+	restart := key == "data_dir" || key == "storage" || key == "socket"
+	if restart {
+		killDaemon()
+	}
+	if err := SetConfigValue(configPath(), key, value); err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: fmt.Sprintf("failed to save config: %v", err)})
+	}
+	if !restart {
+		reloadDaemon()
+	}
+
+	if jsonOutput {
+		printJSON(map[string]string{setting.Key: setting.get(&cfg)})
+		return
+	}
+	fmt.Printf("Set %s = %s\n", key, strconv.Quote(setting.get(&cfg)))
+	if key == "data_dir" {
+		fmt.Println("Existing data isn't moved; move it there yourself to keep it.")
+	}
+	if key == "storage" {
+		fmt.Println("Existing data isn't copied; use memo migrate to switch backends with it.")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []tomlValue
+		err  string
+	}{
+		{
+			name: "empty",
+			text: "",
+		},
+		{
+			name: "comments and blank lines",
+			text: "# memo settings\n\n   # indented comment\n",
+		},
+		{
+			name: "root keys",
+			text: "output = \"json\"\nhook_timeout = \"5s\"\n",
+			want: []tomlValue{{"output", "json", 1}, {"hook_timeout", "5s", 2}},
+		},
+		{
+			name: "tables prefix keys",
+			text: "storage = \"json\"\n\n[idle]\nafter = \"10m\"\n[ display ]\nduration_style = 'clock'\n",
+			want: []tomlValue{{"storage", "json", 1}, {"idle.after", "10m", 4}, {"display.duration_style", "clock", 6}},
+		},
+		{
+			name: "numbers and booleans are kept as text",
+			text: "a = 25\nb = 1.5\nc = true\nd = false # trailing comment\n",
+			want: []tomlValue{{"a", "25", 1}, {"b", "1.5", 2}, {"c", "true", 3}, {"d", "false", 4}},
+		},
+		{
+			name: "basic string escapes",
+			text: `a = "tab\there \"quoted\" back\\slash\nnext"`,
+			want: []tomlValue{{"a", "tab\there \"quoted\" back\\slash\nnext", 1}},
+		},
+		{
+			name: "hash inside strings",
+			text: "a = \"#[bold] # not a comment\" # comment\nb = 'x#y'\n",
+			want: []tomlValue{{"a", "#[bold] # not a comment", 1}, {"b", "x#y", 2}},
+		},
+		{
+			name: "literal strings keep backslashes",
+			text: `dir = 'C:\memo\data'`,
+			want: []tomlValue{{"dir", `C:\memo\data`, 1}},
+		},
+		{
+			name: "table header with comment",
+			text: "[idle] # idle detection\nafter = \"5m\"",
+			want: []tomlValue{{"idle.after", "5m", 2}},
+		},
+		{name: "unterminated table", text: "[idle\n", err: "line 1: invalid table header"},
+		{name: "text after table", text: "[idle] x\n", err: "line 1: invalid table header"},
+		{name: "missing equals", text: "output\n", err: "line 1: expected key = value"},
+		{name: "missing key", text: "= 1\n", err: "line 1: expected key = value"},
+		{name: "missing value", text: "\n\nout = \n", err: "line 3: missing value"},
+		{name: "unquoted string", text: "output = json\n", err: `line 1: invalid value "json" (quote strings)`},
+		{name: "unterminated basic string", text: `a = "abc`, err: "line 1: unterminated string"},
+		{name: "unterminated literal string", text: `a = 'abc`, err: "line 1: unterminated string"},
+		{name: "escape at end", text: `a = "abc\`, err: "line 1: unterminated string"},
+		{name: "unknown escape", text: `a = "\q"`, err: `line 1: unknown escape \q`},
+		{name: "text after string", text: `a = "x" y`, err: "line 1: unexpected text after string"},
+		{name: "text after literal string", text: `a = 'x' y`, err: "line 1: unexpected text after string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.text)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("parseTOML() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTOML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetConfigValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string // "" means there's no file yet
+		key, value string
+		want       string
+	}{
+		{
+			name:  "new file",
+			key:   "output",
+			value: "json",
+			want:  "output = \"json\"\n",
+		},
+		{
+			name:  "new file with table",
+			key:   "idle.after",
+			value: "10m",
+			want:  "[idle]\nafter = \"10m\"\n",
+		},
+		{
+			name:  "replaces a root key in place",
+			file:  "# settings\noutput = \"text\" # was text\nstorage = \"json\"\n",
+			key:   "output",
+			value: "json",
+			want:  "# settings\noutput = \"json\"\nstorage = \"json\"\n",
+		},
+		{
+			name:  "adds a root key before the first table",
+			file:  "[idle]\nafter = \"5m\"\n",
+			key:   "output",
+			value: "json",
+			want:  "output = \"json\"\n[idle]\nafter = \"5m\"\n",
+		},
+		{
+			name:  "replaces a key in a table, not the root one of the same name",
+			file:  "after = \"1m\"\n\n[idle]\nafter = \"5m\"\nreturn = \"ask\"\n",
+			key:   "idle.after",
+			value: "10m",
+			want:  "after = \"1m\"\n\n[idle]\nafter = \"10m\"\nreturn = \"ask\"\n",
+		},
+		{
+			name:  "adds a key after the table's last key",
+			file:  "[idle]\nafter = \"5m\"\n\n[display]\nlayout = \"x\"\n",
+			key:   "idle.return",
+			value: "keep",
+			want:  "[idle]\nafter = \"5m\"\nreturn = \"keep\"\n\n[display]\nlayout = \"x\"\n",
+		},
+		{
+			name:  "adds a missing table at the end",
+			file:  "output = \"json\"\n",
+			key:   "idle.after",
+			value: "5m",
+			want:  "output = \"json\"\n\n[idle]\nafter = \"5m\"\n",
+		},
+		{
+			name:  "ignores commented-out keys",
+			file:  "# output = \"json\"\n",
+			key:   "output",
+			value: "text",
+			want:  "output = \"text\"\n# output = \"json\"\n",
+		},
+		{
+			name:  "quotes values",
+			file:  "",
+			key:   "status",
+			value: `say "hi" \ bye`,
+			want:  "status = \"say \\\"hi\\\" \\\\ bye\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "memo", "config.toml")
+			if tt.file != "" {
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := SetConfigValue(path, tt.key, tt.value); err != nil {
+				t.Fatalf("SetConfigValue() error = %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(data); got != tt.want {
+				t.Errorf("config.toml =\n%s\nwant\n%s", got, tt.want)
+			}
+
+			// Whatever was written must read back as the value set.
+			values, err := parseTOML(string(data))
+			if err != nil {
+				t.Fatalf("parseTOML() of the written file: %v", err)
+			}
+			found := false
+			for _, v := range values {
+				if v.key == tt.key {
+					found = true
+					if v.value != tt.value {
+						t.Errorf("%s reads back as %q, want %q", tt.key, v.value, tt.value)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("%s missing from the written file", tt.key)
+			}
+		})
+	}
+}
+
+func TestSetConfigValueKeepsOtherLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	file := "# memo\n\n[idle]\n# how long before pausing\nafter = \"5m\"\n"
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigValue(path, "idle.after", "15m"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# how long before pausing\n") || !strings.HasPrefix(string(data), "# memo\n\n") {
+		t.Errorf("comments lost:\n%s", data)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultContext = "default"
+
+// State is everything the daemon persists: one task stack per named context
+// and the context currently being worked in.
+type State struct {
+	Current  string                `json:"current"`
+	Contexts map[string]*TaskStack `json:"contexts"`
+	// Idle is set while away from the keyboard, and after coming back
+	// until the away time is dealt with.
+	Idle *IdleState `json:"idle,omitempty"`
+	// Focus is the running focus session or break, if any.
+	Focus *FocusSession `json:"focus,omitempty"`
+	// Removed holds the last revision of each removed context, so that its
+	// revisions carry on rather than start again if it comes back.
+	Removed map[string]int64 `json:"removed,omitempty"`
+}
+
+func newState() *State {
+	return &State{
+		Current:  defaultContext,
+		Contexts: map[string]*TaskStack{defaultContext: {Tasks: []Task{}}},
+	}
+}
+
+// Stack returns the stack for the named context, or nil if there is none.
+func (st *State) Stack(name string) *TaskStack {
+	return st.Contexts[name]
+}
+
+func (st *State) CurrentStack() *TaskStack {
+	return st.Contexts[st.Current]
+}
+
+// Revision returns the revision of the named context's stack, or the last
+// one it had if the context has been removed.
+func (st *State) Revision(name string) int64 {
+	if stack := st.Contexts[name]; stack != nil {
+		return stack.Revision
+	}
+	return st.Removed[name]
+}
+
+// Add puts a stack in place for the named context. A context that was
+// removed before carries on from its last revision.
+func (st *State) Add(name string, stack *TaskStack) {
+	if rev, ok := st.Removed[name]; ok {
+		stack.Revision = max(stack.Revision, rev+1)
+		delete(st.Removed, name)
+	}
+	st.Contexts[name] = stack
+}
+
+// Remove drops the named context, remembering its revision.
+func (st *State) Remove(name string) {
+	if st.Removed == nil {
+		st.Removed = map[string]int64{}
+	}
+	st.Removed[name] = st.Contexts[name].Revision
+	delete(st.Contexts, name)
+}
+
+// Names returns the context names in sorted order.
+func (st *State) Names() []string {
+	names := make([]string, 0, len(st.Contexts))
+	for name := range st.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validContextName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("context name required")
+	}
+	if strings.ContainsAny(name, " \t/\\") {
+		return fmt.Errorf("context name %q may not contain spaces or slashes", name)
+	}
+	return nil
+}
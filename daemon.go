@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -10,6 +13,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,14 +22,13 @@ import (
 )
 
 func memoDir() string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-	return filepath.Join(home, ".memo")
+	return config.Load().DataDir
 }
 
 func socketPath() string {
+	if sock := config.Load().Socket; sock != "" {
+		return sock
+	}
 	return filepath.Join(memoDir(), "memo.sock")
 }
 
@@ -33,12 +36,20 @@ func pidPath() string {
 	return filepath.Join(memoDir(), "memo.pid")
 }
 
-func statePath() string {
-	return filepath.Join(memoDir(), "state.json")
+func hooksDir() string {
+	return hooksDirIn(memoDir())
+}
+
+// hooksDirIn is hooks_dir, or the hooks directory in dataDir.
+func hooksDirIn(dataDir string) string {
+	if dir := config.Load().HooksDir; dir != "" {
+		return dir
+	}
+	return filepath.Join(dataDir, "hooks")
 }
 
-func logPath() string {
-	return filepath.Join(memoDir(), "log.jsonl")
+func hooksLogPath(dataDir string) string {
+	return filepath.Join(dataDir, "hooks.log")
 }
 
 func runDaemon() {
@@ -47,11 +58,22 @@ func runDaemon() {
 		log.Fatalf("failed to create data directory: %v", err)
 	}
 
-	stack, err := LoadState(statePath())
+	store, err := openStorage(config.Load().Storage, dir)
+	if err != nil {
+		log.Fatalf("failed to open storage: %v", err)
+	}
+	defer store.Close()
+
+	state, err := store.LoadState()
 	if err != nil {
 		log.Fatalf("failed to load state: %v", err)
 	}
 
+	journal, err := store.LoadJournal()
+	if err != nil {
+		log.Fatalf("failed to load journal: %v", err)
+	}
+
 	sock := socketPath()
 	// Clean up stale socket
 	if _, err := os.Stat(sock); err == nil {
@@ -64,11 +86,347 @@ func runDaemon() {
 	}
 
 	// Write PID file
-	if err := os.WriteFile(pidPath(), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+	pid := pidPath()
+	if err := os.WriteFile(pid, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
 		log.Fatalf("failed to write PID file: %v", err)
 	}
 
+	idleConfig, err := loadIdleSettings(config.Load())
+	if err != nil {
+		log.Printf("idle detection disabled: %v", err)
+	}
+
 	var mu sync.Mutex
+	bus := newEventBus()
+	go runHooks(bus.Queue(), dir)
+
+	// logged collects the log entries written by the handler holding mu so
+	// that commit can journal them along with the mutation.
+	var logged []LogEntry
+	stopTask := func(ctx string, task Task, now time.Time, reason string) {
+		if entry, err := LogTaskStop(store, ctx, task, now, reason); err == nil {
+			logged = append(logged, entry)
+		}
+	}
+
+	// stopStrayFocus stops the focus session once its task is no longer the
+	// running top task, so it isn't logged as a full pomodoro. It's set up
+	// with the other focus helpers below.
+	var stopStrayFocus func(now time.Time)
+
+	// commit persists the state after a mutation of a context's stack and
+	// journals the change so it can be undone.
+	commit := func(ctx, op, task string, before *TaskStack) {
+		state.Stack(ctx).Revision = before.Revision + 1
+		journal.Record(JournalEntry{
+			Op:      op,
+			Context: ctx,
+			Task:    task,
+			At:      time.Now().UTC(),
+			Before:  *before,
+			After:   *state.Stack(ctx).Clone(),
+			Logged:  logged,
+		})
+		logged = nil
+		store.SaveState(state)
+		store.SaveJournal(journal)
+		bus.Publish(diffEvents(ctx, op, before, state.Stack(ctx), ctx == state.Current)...)
+		stopStrayFocus(time.Now().UTC())
+	}
+
+	// settle keeps only the top task of the current context running.
+	settle := func(ctx string, stack *TaskStack, now time.Time) {
+		if ctx == state.Current {
+			stack.Settle(now)
+		} else {
+			stack.PauseAll(now)
+		}
+	}
+
+	// conflict replies 409 and returns true if the request expects a
+	// revision of the named context's stack and the stack has moved on.
+	conflict := func(w http.ResponseWriter, r *http.Request, ctx string) bool {
+		expected, ok := r.Context().Value(revisionKey{}).(int64)
+		if !ok {
+			return false
+		}
+		revision := state.Revision(ctx)
+		// Coming back or ending a break on the request's account doesn't
+		// count as a change.
+		moved, _ := r.Context().Value(movedKey{}).(map[string]int64)
+		if expected+moved[ctx] == revision {
+			return false
+		}
+		http.Error(w, fmt.Sprintf("the stack has changed (revision %d, expected %d); reload it and try again", revision, expected), http.StatusConflict)
+		return true
+	}
+
+	// lookup returns the context a request targets, either named by its
+	// context query parameter or the current one, and that context's stack.
+	// If the request expects a revision of the stack and it has moved on,
+	// lookup replies 409 and returns a nil stack.
+	lookup := func(w http.ResponseWriter, r *http.Request) (string, *TaskStack) {
+		ctx := r.URL.Query().Get("context")
+		if ctx == "" {
+			ctx = state.Current
+		}
+		stack := state.Stack(ctx)
+		if stack == nil {
+			http.Error(w, fmt.Sprintf("unknown context %q", ctx), http.StatusNotFound)
+			return ctx, nil
+		}
+		if conflict(w, r, ctx) {
+			return ctx, nil
+		}
+		return ctx, stack
+	}
+
+	// restore puts a journaled stack back in place, recreating its context
+	// if it has been removed since. The stack comes back exactly as it was
+	// unless the current context changed in the meantime.
+	restore := func(op, ctx string, stack *TaskStack) {
+		before := &TaskStack{Revision: state.Revision(ctx)}
+		if prev := state.Stack(ctx); prev != nil {
+			before = prev
+		}
+		stack = stack.Clone()
+		stack.Revision = before.Revision + 1
+		settle(ctx, stack, time.Now().UTC())
+		state.Add(ctx, stack)
+		bus.Publish(diffEvents(ctx, op, before, stack, ctx == state.Current)...)
+		stopStrayFocus(time.Now().UTC())
+	}
+
+	// goIdle pauses the running task as of since, when activity stopped.
+	// The pause isn't journaled: it's undone by coming back, not by undo.
+	goIdle := func(since time.Time, manual bool) error {
+		ctx, stack := state.Current, state.CurrentStack()
+		top := stack.Peek()
+		if top == nil || !top.Running() {
+			return fmt.Errorf("no task is running")
+		}
+		if start := top.openSegment().Start; since.Before(start) {
+			since = start
+		}
+		LogTaskStop(store, ctx, *top, since, "idle")
+		top.Pause(since)
+		stack.Revision++
+		state.Idle = &IdleState{Since: since, Context: ctx, TaskID: top.ID, Task: top.Label(), Manual: manual}
+		store.SaveState(state)
+		bus.Publish(Event{Type: "idle", Context: ctx, Task: topCopy(stack), At: since})
+		stopStrayFocus(since)
+		return nil
+	}
+
+	// resolveIdle deals with the time away once back: "keep" counts it
+	// towards the task that was running, "reassign" towards the task with
+	// the given ID and "discard" drops it.
+	resolveIdle := func(action, id string) (*Task, error) {
+		idle := state.Idle
+		if action == "discard" {
+			state.Idle = nil
+			store.SaveState(state)
+			return nil, nil
+		}
+		if action == "keep" {
+			id = idle.TaskID
+		}
+		stack := state.Stack(idle.Context)
+		if stack == nil {
+			return nil, fmt.Errorf("context %q no longer exists", idle.Context)
+		}
+		before := stack.Clone()
+		_, task := stack.Find(id)
+		if task == nil {
+			return nil, fmt.Errorf("no task with ID %s in context %s", id, idle.Context)
+		}
+		task.AddSegment(idle.Since, *idle.Returned)
+		entry := LogEntry{
+			ID:      task.ID,
+			Context: idle.Context,
+			Task:    task.Description,
+			Tags:    task.Tags,
+			Project: task.Project,
+			Started: task.StartedAt.Format(time.RFC3339),
+			Resumed: idle.Since.Format(time.RFC3339),
+			Stopped: idle.Returned.Format(time.RFC3339),
+			Reason:  map[string]string{"keep": "kept", "reassign": "reassigned"}[action],
+			Active:  task.Active(*idle.Returned).Round(time.Second).String(),
+		}
+		if store.AppendLog(entry) == nil {
+			logged = append(logged, entry)
+		}
+		state.Idle = nil
+		commit(idle.Context, "idle", task.Description, before)
+		copy := *task
+		return &copy, nil
+	}
+
+	// wakeUp ends a spell away at the given time, resuming the task that
+	// was paused, and deals with the away time as configured.
+	wakeUp := func(at time.Time) {
+		idle := state.Idle
+		idle.Returned = &at
+		stack := state.CurrentStack()
+		stack.Settle(at)
+		stack.Revision++
+		store.SaveState(state)
+		bus.Publish(Event{Type: "back", Context: state.Current, Task: topCopy(stack), At: at})
+
+		switch idleConfig.Return {
+		case "keep":
+			if _, err := resolveIdle("keep", ""); err != nil {
+				resolveIdle("discard", "")
+			}
+		case "discard":
+			resolveIdle("discard", "")
+		}
+	}
+
+	// endFocus ends the focus session or break at the given time. A focus
+	// session that ran its full length is logged as a pomodoro for its
+	// task. A break is logged on its own and the top task resumes.
+	endFocus := func(at time.Time) {
+		session := *state.Focus
+		state.Focus = nil
+		if session.Break {
+			stack := state.CurrentStack()
+			stack.Settle(at)
+			stack.Revision++
+			store.AppendLog(LogEntry{
+				Context: session.Context,
+				Started: session.Start.Format(time.RFC3339),
+				Stopped: at.Format(time.RFC3339),
+				Reason:  "break",
+			})
+			store.SaveState(state)
+			bus.Publish(Event{Type: "break-ended", Context: state.Current, Task: topCopy(stack), At: at})
+			return
+		}
+
+		var task *Task
+		if stack := state.Stack(session.Context); stack != nil {
+			if _, t := stack.Find(session.TaskID); t != nil {
+				copy := t.Copy()
+				task = &copy
+			}
+		}
+		typ := "focus-stopped"
+		if !at.Before(session.End) {
+			typ = "focus-ended"
+			entry := LogEntry{ID: session.TaskID, Context: session.Context, Task: session.Task}
+			if task != nil {
+				entry.Task, entry.Tags, entry.Project = task.Description, task.Tags, task.Project
+			}
+			entry.Started = session.Start.Format(time.RFC3339)
+			entry.Stopped = session.End.Format(time.RFC3339)
+			entry.Reason = "focus"
+			store.AppendLog(entry)
+		}
+		store.SaveState(state)
+		bus.Publish(Event{Type: typ, Context: session.Context, Task: task, At: at})
+	}
+
+	// scheduleFocus arranges for the current focus session or break to end
+	// on time.
+	var focusTimer *time.Timer
+	scheduleFocus := func() {
+		if focusTimer != nil {
+			focusTimer.Stop()
+		}
+		if state.Focus == nil {
+			return
+		}
+		session := *state.Focus
+		focusTimer = time.AfterFunc(time.Until(session.End), func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if state.Focus != nil && *state.Focus == session {
+				endFocus(session.End)
+			}
+		})
+	}
+	scheduleFocus()
+
+	stopStrayFocus = func(now time.Time) {
+		f := state.Focus
+		if f == nil || f.Break {
+			return
+		}
+		top := state.CurrentStack().Peek()
+		if f.Context == state.Current && top != nil && top.ID == f.TaskID && top.Running() {
+			return
+		}
+		endFocus(now)
+		scheduleFocus()
+	}
+
+	// activity notes that the user did something, ending any automatic or
+	// manual spell away, and any break. It returns how far that moved each
+	// context's revision on, so the request that caused it isn't refused
+	// for it.
+	lastActivity := time.Now().UTC()
+	activity := func() map[string]int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		lastActivity = time.Now().UTC()
+		revisions := map[string]int64{}
+		for name, stack := range state.Contexts {
+			revisions[name] = stack.Revision
+		}
+		if state.Idle != nil && state.Idle.Returned == nil {
+			wakeUp(lastActivity)
+		}
+		if state.Focus != nil && state.Focus.Break {
+			endFocus(lastActivity)
+		}
+		moved := map[string]int64{}
+		for name, stack := range state.Contexts {
+			if stack.Revision != revisions[name] {
+				moved[name] = stack.Revision - revisions[name]
+			}
+		}
+		return moved
+	}
+
+	// watchIdle pauses the running task once neither memo nor the idle
+	// probe has seen activity for the configured time, and comes back
+	// when the probe sees activity again.
+	watchIdle := func() {
+		for range time.Tick(idleCheckInterval) {
+			mu.Lock()
+			settings := idleConfig
+			mu.Unlock()
+			if settings.After == 0 {
+				continue
+			}
+			var probed time.Duration
+			probeErr := fmt.Errorf("no idle probe")
+			if settings.Probe != nil {
+				probed, probeErr = settings.Probe.Idle()
+			}
+
+			mu.Lock()
+			now := time.Now().UTC()
+			idleFor := now.Sub(lastActivity)
+			if probeErr == nil {
+				idleFor = min(idleFor, probed)
+			}
+			switch idle := state.Idle; {
+			case idle == nil:
+				if idleFor >= settings.After {
+					goIdle(now.Add(-idleFor), false)
+				}
+			case idle.Returned == nil && !idle.Manual && probeErr == nil:
+				if back := now.Add(-probed); back.After(idle.Since) {
+					lastActivity = back
+					wakeUp(back)
+				}
+			}
+			mu.Unlock()
+		}
+	}
+	go watchIdle()
 
 	mux := http.NewServeMux()
 
@@ -86,47 +444,90 @@ func runDaemon() {
 		}
 		mu.Lock()
 		defer mu.Unlock()
+		_, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", fmt.Sprintf("%q", strconv.FormatInt(stack.Revision, 10)))
 		json.NewEncoder(w).Encode(stack)
 	})
 
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newStatus(ctx, stack, state, time.Now().UTC()))
+	})
+
 	mux.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		var req struct {
-			Description string `json:"description"`
+			Description string   `json:"description"`
+			Tags        []string `json:"tags"`
+			Project     string   `json:"project"`
+			// Note is added to the task being paused.
+			Note string `json:"note"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		if strings.TrimSpace(req.Description) == "" {
+		req.Description, req.Tags, req.Project = parseDescription(req.Description, req.Tags, req.Project)
+		if req.Description == "" {
 			http.Error(w, "description required", http.StatusBadRequest)
 			return
 		}
 
 		mu.Lock()
 		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
 
 		now := time.Now().UTC()
-		var paused *Task
+		hadTop := false
 		if top := stack.Peek(); top != nil {
-			copy := *top
-			paused = &copy
-			LogTaskStop(logPath(), *top, now, "pushed")
+			hadTop = true
+			if strings.TrimSpace(req.Note) != "" {
+				top.AddNote(now, req.Note)
+			}
+			if top.Running() {
+				stopTask(ctx, *top, now, "pushed")
+			}
 		}
 
-		stack.Push(req.Description)
-		SaveState(stack, statePath())
+		pushed := stack.Push(req.Description)
+		pushed.Tags, pushed.Project = req.Tags, req.Project
+		settle(ctx, stack, now)
+		commit(ctx, "push", req.Description, before)
+
+		var paused *Task
+		if hadTop {
+			paused = &stack.Tasks[1]
+		}
 
 		resp := struct {
-			Started Task  `json:"started"`
-			Paused  *Task `json:"paused,omitempty"`
+			Started  Task  `json:"started"`
+			Paused   *Task `json:"paused,omitempty"`
+			Revision int64 `json:"revision"`
 		}{
-			Started: *stack.Peek(),
-			Paused:  paused,
+			Started:  *stack.Peek(),
+			Paused:   paused,
+			Revision: stack.Revision,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
@@ -138,21 +539,40 @@ func runDaemon() {
 			return
 		}
 
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
 		mu.Lock()
 		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
 
-		popped := stack.Pop()
+		popped, wasTop := takeTask(stack, req.ID)
 		if popped == nil {
-			http.Error(w, "stack is empty", http.StatusBadRequest)
+			if req.ID != "" {
+				http.Error(w, fmt.Sprintf("no task with ID %s", req.ID), http.StatusNotFound)
+			} else {
+				http.Error(w, "stack is empty", http.StatusBadRequest)
+			}
 			return
 		}
 
 		now := time.Now().UTC()
-		LogTaskStop(logPath(), *popped, now, "popped")
-		SaveState(stack, statePath())
+		stopTask(ctx, *popped, now, "popped")
+		popped.Pause(now)
+		settle(ctx, stack, now)
+		commit(ctx, "pop", popped.Description, before)
 
 		var resuming *Task
-		if top := stack.Peek(); top != nil {
+		if top := stack.Peek(); top != nil && wasTop {
 			copy := *top
 			resuming = &copy
 		}
@@ -160,9 +580,11 @@ func runDaemon() {
 		resp := struct {
 			Popped   Task  `json:"popped"`
 			Resuming *Task `json:"resuming,omitempty"`
+			Revision int64 `json:"revision"`
 		}{
 			Popped:   *popped,
 			Resuming: resuming,
+			Revision: stack.Revision,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
@@ -174,21 +596,40 @@ func runDaemon() {
 			return
 		}
 
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
 		mu.Lock()
 		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
 
-		dropped := stack.Pop()
+		dropped, wasTop := takeTask(stack, req.ID)
 		if dropped == nil {
-			http.Error(w, "stack is empty", http.StatusBadRequest)
+			if req.ID != "" {
+				http.Error(w, fmt.Sprintf("no task with ID %s", req.ID), http.StatusNotFound)
+			} else {
+				http.Error(w, "stack is empty", http.StatusBadRequest)
+			}
 			return
 		}
 
 		now := time.Now().UTC()
-		LogTaskStop(logPath(), *dropped, now, "dropped")
-		SaveState(stack, statePath())
+		stopTask(ctx, *dropped, now, "dropped")
+		dropped.Pause(now)
+		settle(ctx, stack, now)
+		commit(ctx, "drop", dropped.Description, before)
 
 		var resuming *Task
-		if top := stack.Peek(); top != nil {
+		if top := stack.Peek(); top != nil && wasTop {
 			copy := *top
 			resuming = &copy
 		}
@@ -196,9 +637,11 @@ func runDaemon() {
 		resp := struct {
 			Dropped  Task  `json:"dropped"`
 			Resuming *Task `json:"resuming,omitempty"`
+			Revision int64 `json:"revision"`
 		}{
 			Dropped:  *dropped,
 			Resuming: resuming,
+			Revision: stack.Revision,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
@@ -212,6 +655,11 @@ func runDaemon() {
 
 		mu.Lock()
 		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
 
 		started, paused := stack.Switch()
 		if started == nil {
@@ -220,160 +668,1178 @@ func runDaemon() {
 		}
 
 		now := time.Now().UTC()
-		LogTaskStop(logPath(), *paused, now, "switched")
-		SaveState(stack, statePath())
+		if paused.Running() {
+			stopTask(ctx, *paused, now, "switched")
+		}
+		settle(ctx, stack, now)
+		commit(ctx, "switch", started.Description, before)
 
 		resp := struct {
-			Started Task `json:"started"`
-			Paused  Task `json:"paused"`
+			Started  Task  `json:"started"`
+			Paused   Task  `json:"paused"`
+			Revision int64 `json:"revision"`
 		}{
-			Started: *started,
-			Paused:  *paused,
+			Started:  *started,
+			Paused:   *paused,
+			Revision: stack.Revision,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
 	})
 
-	mux.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		var req struct {
-			Description string `json:"description"`
+			ID string `json:"id"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		if strings.TrimSpace(req.Description) == "" {
-			http.Error(w, "description required", http.StatusBadRequest)
-			return
-		}
 
 		mu.Lock()
 		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
 
-		queued := stack.Queue(req.Description)
-		SaveState(stack, statePath())
+		i, _ := stack.Find(req.ID)
+		if i < 0 {
+			http.Error(w, fmt.Sprintf("no task with ID %s", req.ID), http.StatusNotFound)
+			return
+		}
+
+		var paused *Task
+		if i > 0 {
+			now := time.Now().UTC()
+			if top := stack.Peek(); top.Running() {
+				stopTask(ctx, *top, now, "switched")
+			}
+			stack.MoveToTop(req.ID)
+			settle(ctx, stack, now)
+			paused = &stack.Tasks[1]
+			commit(ctx, "resume", stack.Tasks[0].Description, before)
+		}
 
 		resp := struct {
-			Queued  Task  `json:"queued"`
-			Current *Task `json:"current,omitempty"`
+			Started  Task  `json:"started"`
+			Paused   *Task `json:"paused,omitempty"`
+			Revision int64 `json:"revision"`
 		}{
-			Queued:  *queued,
-			Current: stack.Peek(),
+			Started:  *stack.Peek(),
+			Paused:   paused,
+			Revision: stack.Revision,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
 	})
 
-	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+	mux.HandleFunc("/insert", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		entries, err := LoadLog(logPath())
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to load log: %v", err), http.StatusInternalServerError)
+		var req struct {
+			Description string   `json:"description"`
+			Tags        []string `json:"tags"`
+			Project     string   `json:"project"`
+			// Position is 1-based from the top; After names a task to
+			// insert right below instead.
+			Position int    `json:"position"`
+			After    string `json:"after"`
+			// Note is added to the task being paused, if the new task
+			// lands on top.
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
+		req.Description, req.Tags, req.Project = parseDescription(req.Description, req.Tags, req.Project)
+		if req.Description == "" {
+			http.Error(w, "description required", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
+
+		position := req.Position
+		if req.After != "" {
+			i, _ := stack.Find(req.After)
+			if i < 0 {
+				http.Error(w, fmt.Sprintf("no task with ID %s", req.After), http.StatusNotFound)
+				return
+			}
+			position = i + 2
+		}
+		if position < 1 || position > stack.Len()+1 {
+			http.Error(w, fmt.Sprintf("position must be between 1 and %d", stack.Len()+1), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		var paused *Task
+		if top := stack.Peek(); top != nil && position == 1 {
+			if strings.TrimSpace(req.Note) != "" {
+				top.AddNote(now, req.Note)
+			}
+			if top.Running() {
+				stopTask(ctx, *top, now, "pushed")
+			}
+		}
+
+		inserted := stack.Insert(position, req.Description)
+		inserted.Tags, inserted.Project = req.Tags, req.Project
+		settle(ctx, stack, now)
+		commit(ctx, "insert", req.Description, before)
+		if position == 1 && stack.Len() > 1 {
+			paused = &stack.Tasks[1]
+		}
+
+		resp := struct {
+			Inserted Task  `json:"inserted"`
+			Position int   `json:"position"`
+			Paused   *Task `json:"paused,omitempty"`
+			Revision int64 `json:"revision"`
+		}{
+			Inserted: stack.Tasks[position-1],
+			Position: position,
+			Paused:   paused,
+			Revision: stack.Revision,
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(entries)
+		json.NewEncoder(w).Encode(resp)
 	})
 
-	mux.HandleFunc("/reorder", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/note", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		var req struct {
-			Order []int `json:"order"`
+			ID   string `json:"id"`
+			Text string `json:"text"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
+		if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "note text required", http.StatusBadRequest)
+			return
+		}
 
 		mu.Lock()
 		defer mu.Unlock()
-
-		oldTop := stack.Peek()
-		var oldTopDesc string
-		if oldTop != nil {
-			oldTopDesc = oldTop.Description
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
 		}
+		before := stack.Clone()
 
-		if err := stack.Reorder(req.Order); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		task := stack.Peek()
+		if req.ID != "" {
+			_, task = stack.Find(req.ID)
+		}
+		if task == nil {
+			if req.ID != "" {
+				http.Error(w, fmt.Sprintf("no task with ID %s", req.ID), http.StatusNotFound)
+			} else {
+				http.Error(w, "stack is empty", http.StatusBadRequest)
+			}
 			return
 		}
 
-		now := time.Now().UTC()
-		newTop := stack.Peek()
-		if oldTop != nil && newTop != nil && oldTopDesc != newTop.Description {
-			LogTaskStop(logPath(), Task{Description: oldTopDesc, StartedAt: oldTop.StartedAt}, now, "reordered")
-		}
-		SaveState(stack, statePath())
+		task.AddNote(time.Now().UTC(), req.Text)
+		commit(ctx, "note", task.Description, before)
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(stack)
+		json.NewEncoder(w).Encode(struct {
+			Task     Task  `json:"task"`
+			Revision int64 `json:"revision"`
+		}{*task, stack.Revision})
 	})
 
-	// Handle signals for clean shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		<-sigCh
-		ln.Close()
-		os.Remove(sock)
-		os.Remove(pidPath())
-		os.Exit(0)
-	}()
-
-	server := &http.Server{Handler: mux}
-	if err := server.Serve(ln); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
-		log.Fatalf("server error: %v", err)
-	}
-}
+	mux.HandleFunc("/edit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ID string `json:"id"`
+			// Position is 1-based from the top. Without an ID or position
+			// the top task is edited.
+			Position    int      `json:"position"`
+			Description string   `json:"description"`
+			Tags        []string `json:"tags"`
+			Project     string   `json:"project"`
+			// Replace makes the new tags and project the task's, even if
+			// there are none, for editors that show the whole label.
+			Replace bool `json:"replace"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		description, tags, project := parseDescription(req.Description, req.Tags, req.Project)
+		if description == "" {
+			http.Error(w, "description required", http.StatusBadRequest)
+			return
+		}
 
-func ensureDaemon() {
-	sock := socketPath()
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
 
-	// Try connecting to existing daemon
-	if tryConnect(sock) {
-		return
-	}
+		var task *Task
+		switch {
+		case req.ID != "":
+			if _, task = stack.Find(req.ID); task == nil {
+				http.Error(w, fmt.Sprintf("no task with ID %s", req.ID), http.StatusNotFound)
+				return
+			}
+		case req.Position != 0:
+			if task = stack.At(req.Position); task == nil {
+				http.Error(w, fmt.Sprintf("no task at position %d", req.Position), http.StatusNotFound)
+				return
+			}
+		default:
+			if task = stack.Peek(); task == nil {
+				http.Error(w, "stack is empty", http.StatusBadRequest)
+				return
+			}
+		}
 
-	// Check PID file
-	if data, err := os.ReadFile(pidPath()); err == nil {
-		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
-			if process, err := os.FindProcess(pid); err == nil {
-				if err := process.Signal(syscall.Signal(0)); err == nil {
-					// Process exists, wait for socket
-					for i := 0; i < 20; i++ {
-						time.Sleep(100 * time.Millisecond)
-						if tryConnect(sock) {
-							return
-						}
-					}
-				}
+		// Tags and project are kept unless the new description gives some,
+		// or the request replaces them.
+		previous := task.Label()
+		task.Description = description
+		if len(tags) > 0 || req.Replace {
+			task.Tags = tags
+		}
+		if project != "" || req.Replace {
+			task.Project = project
+		}
+		if task.Label() != previous {
+			if entry, err := LogTaskEdit(store, ctx, *task, previous, time.Now().UTC()); err == nil {
+				logged = append(logged, entry)
 			}
+			commit(ctx, "edit", task.Description, before)
 		}
-	}
 
-	// Start daemon
-	exe, err := os.Executable()
-	if err != nil {
-		log.Fatalf("failed to find executable: %v", err)
-	}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Task     Task   `json:"task"`
+			Previous string `json:"previous"`
+			Revision int64  `json:"revision"`
+		}{*task, previous, stack.Revision})
+	})
+
+	mux.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Description string   `json:"description"`
+			Tags        []string `json:"tags"`
+			Project     string   `json:"project"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		req.Description, req.Tags, req.Project = parseDescription(req.Description, req.Tags, req.Project)
+		if req.Description == "" {
+			http.Error(w, "description required", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
+
+		queued := stack.Queue(req.Description)
+		queued.Tags, queued.Project = req.Tags, req.Project
+		settle(ctx, stack, time.Now().UTC())
+		commit(ctx, "queue", queued.Description, before)
+
+		resp := struct {
+			Queued   Task  `json:"queued"`
+			Current  *Task `json:"current,omitempty"`
+			Revision int64 `json:"revision"`
+		}{
+			Queued:   *queued,
+			Current:  stack.Peek(),
+			Revision: stack.Revision,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	// /import adds many tasks as one change, queued in order or, with push,
+	// pushed so the first of them ends up on top. Tasks with the same
+	// description as one already on the stack are skipped and the tasks
+	// they duplicate returned; tasks listed more than once are only added
+	// once and the repeats returned separately.
+	mux.HandleFunc("/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Tasks []struct {
+				Description string   `json:"description"`
+				Tags        []string `json:"tags"`
+				Project     string   `json:"project"`
+			} `json:"tasks"`
+			Push bool `json:"push"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
+
+		imported, duplicates, repeats := []Task{}, []Task{}, []string{}
+		listed := map[string]bool{}
+		for _, t := range req.Tasks {
+			desc, tags, project := parseDescription(t.Description, t.Tags, t.Project)
+			if desc == "" {
+				continue
+			}
+			if i := slices.IndexFunc(before.Tasks, func(t Task) bool { return strings.EqualFold(t.Description, desc) }); i >= 0 {
+				duplicates = append(duplicates, before.Tasks[i])
+				continue
+			}
+			key := strings.ToLower(desc)
+			if listed[key] {
+				repeats = append(repeats, labelled(desc, tags, project))
+				continue
+			}
+			listed[key] = true
+			task := stack.Queue(desc)
+			task.Tags, task.Project = tags, project
+		}
+
+		if n := before.Len(); len(stack.Tasks) > n {
+			now := time.Now().UTC()
+			if req.Push {
+				// Move the new tasks from the bottom to the top, in order.
+				if top := stack.Peek(); n > 0 && top.Running() {
+					stopTask(ctx, *top, now, "pushed")
+				}
+				stack.Tasks = slices.Concat(stack.Tasks[n:], stack.Tasks[:n])
+			}
+			settle(ctx, stack, now)
+			commit(ctx, "import", plural(len(stack.Tasks)-n, "task"), before)
+			for _, t := range stack.Tasks {
+				if i, _ := before.Find(t.ID); i < 0 {
+					imported = append(imported, t)
+				}
+			}
+		}
+
+		resp := struct {
+			Imported   []Task   `json:"imported"`
+			Duplicates []Task   `json:"duplicates"`
+			Repeats    []string `json:"repeats"`
+			Revision   int64    `json:"revision"`
+		}{
+			Imported:   imported,
+			Duplicates: duplicates,
+			Repeats:    repeats,
+			Revision:   stack.Revision,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/undo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if n := len(journal.Undo); n > 0 && conflict(w, r, journal.Undo[n-1].ContextName()) {
+			return
+		}
+		entry := journal.PopUndo()
+		if entry == nil {
+			http.Error(w, "nothing to undo", http.StatusBadRequest)
+			return
+		}
+
+		// Cancel the log entries the mutation wrote so history doesn't
+		// count them.
+		now := time.Now().UTC()
+		for i := len(entry.Logged) - 1; i >= 0; i-- {
+			l := entry.Logged[i]
+			store.AppendLog(LogEntry{
+				ID:      l.ID,
+				Context: l.Context,
+				Task:    l.Task,
+				Tags:    l.Tags,
+				Project: l.Project,
+				Started: l.Started,
+				Stopped: now.Format(time.RFC3339),
+				Reason:  "undone",
+				Undoes:  l.Reason,
+			})
+		}
+		restore("undo", entry.ContextName(), &entry.Before)
+		store.SaveState(state)
+		store.SaveJournal(journal)
+
+		resp := struct {
+			Op       string `json:"op"`
+			Context  string `json:"context"`
+			Task     string `json:"task"`
+			Current  *Task  `json:"current,omitempty"`
+			Revision int64  `json:"revision"`
+		}{
+			Op:       entry.Op,
+			Context:  entry.ContextName(),
+			Task:     entry.Task,
+			Current:  state.CurrentStack().Peek(),
+			Revision: state.Stack(entry.ContextName()).Revision,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/redo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if n := len(journal.Redo); n > 0 && conflict(w, r, journal.Redo[n-1].ContextName()) {
+			return
+		}
+		entry := journal.PopRedo()
+		if entry == nil {
+			http.Error(w, "nothing to redo", http.StatusBadRequest)
+			return
+		}
+
+		for _, l := range entry.Logged {
+			store.AppendLog(l)
+		}
+		restore("redo", entry.ContextName(), &entry.After)
+		store.SaveState(state)
+		store.SaveJournal(journal)
+
+		resp := struct {
+			Op       string `json:"op"`
+			Context  string `json:"context"`
+			Task     string `json:"task"`
+			Current  *Task  `json:"current,omitempty"`
+			Revision int64  `json:"revision"`
+		}{
+			Op:       entry.Op,
+			Context:  entry.ContextName(),
+			Task:     entry.Task,
+			Current:  state.CurrentStack().Peek(),
+			Revision: state.Stack(entry.ContextName()).Revision,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := ParseLogFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := store.LoadLog(filter.Since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to load log: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(filter.Apply(entries))
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ctx := r.URL.Query().Get("context")
+
+		events := bus.Subscribe()
+		defer bus.Unsubscribe(events)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(30 * time.Second)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case e := <-events:
+				if ctx != "" && e.Context != ctx {
+					continue
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			}
+			flusher.Flush()
+		}
+	})
+
+	mux.HandleFunc("/contexts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		type contextInfo struct {
+			Name    string `json:"name"`
+			Current bool   `json:"current"`
+			Tasks   int    `json:"tasks"`
+			Top     *Task  `json:"top,omitempty"`
+		}
+		contexts := []contextInfo{}
+		for _, name := range state.Names() {
+			stack := state.Stack(name)
+			contexts = append(contexts, contextInfo{
+				Name:    name,
+				Current: name == state.Current,
+				Tasks:   stack.Len(),
+				Top:     stack.Peek(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contexts)
+	})
+
+	mux.HandleFunc("/context/new", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := validContextName(req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if state.Stack(req.Name) != nil {
+			http.Error(w, fmt.Sprintf("context %q already exists", req.Name), http.StatusConflict)
+			return
+		}
+		state.Add(req.Name, &TaskStack{Tasks: []Task{}})
+		store.SaveState(state)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/context/use", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		next := state.Stack(req.Name)
+		if next == nil {
+			http.Error(w, fmt.Sprintf("unknown context %q", req.Name), http.StatusNotFound)
+			return
+		}
+		if conflict(w, r, state.Current) {
+			return
+		}
+
+		var paused *Task
+		if req.Name != state.Current {
+			now := time.Now().UTC()
+			prevName, prev := state.Current, state.CurrentStack()
+			if top := prev.Peek(); top != nil && top.Running() {
+				LogTaskStop(store, prevName, *top, now, "context")
+			}
+			prev.PauseAll(now)
+			paused = prev.Peek()
+			state.Current = req.Name
+			next.Settle(now)
+			prev.Revision++
+			next.Revision++
+			store.SaveState(state)
+
+			if paused != nil {
+				bus.Publish(Event{Type: "paused", Context: prevName, Task: topCopy(prev), Previous: topCopy(prev), At: now})
+			}
+			if top := topCopy(next); top != nil {
+				bus.Publish(Event{Type: "started", Context: req.Name, Task: top, Next: top, At: now})
+			}
+			stopStrayFocus(now)
+		}
+
+		resp := struct {
+			Context  string `json:"context"`
+			Paused   *Task  `json:"paused,omitempty"`
+			Resuming *Task  `json:"resuming,omitempty"`
+			Revision int64  `json:"revision"`
+		}{
+			Context:  state.Current,
+			Paused:   paused,
+			Resuming: next.Peek(),
+			Revision: next.Revision,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/context/rm", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		stack := state.Stack(req.Name)
+		if stack == nil {
+			http.Error(w, fmt.Sprintf("unknown context %q", req.Name), http.StatusNotFound)
+			return
+		}
+		if req.Name == state.Current {
+			http.Error(w, "can't remove the current context", http.StatusConflict)
+			return
+		}
+		if stack.Len() > 0 {
+			http.Error(w, fmt.Sprintf("context %q still has %s", req.Name, plural(stack.Len(), "task")), http.StatusConflict)
+			return
+		}
+		state.Remove(req.Name)
+		store.SaveState(state)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/reorder", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Order []int    `json:"order"`
+			IDs   []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, stack := lookup(w, r)
+		if stack == nil {
+			return
+		}
+		before := stack.Clone()
+
+		var err error
+		if req.IDs != nil {
+			err = stack.ReorderIDs(req.IDs)
+		} else {
+			err = stack.Reorder(req.Order)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		if newTop := stack.Peek(); newTop != nil {
+			if oldTop := before.Peek(); oldTop.ID != newTop.ID && oldTop.Running() {
+				stopTask(ctx, *oldTop, now, "reordered")
+			}
+			settle(ctx, stack, now)
+			commit(ctx, "reorder", newTop.Description, before)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stack)
+	})
+
+	mux.HandleFunc("/idle", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if conflict(w, r, state.Current) {
+				return
+			}
+			if state.Idle != nil && state.Idle.Returned == nil {
+				http.Error(w, "already away", http.StatusConflict)
+				return
+			}
+			if state.Idle != nil {
+				http.Error(w, "deal with the last time away first", http.StatusConflict)
+				return
+			}
+			if err := goIdle(time.Now().UTC(), true); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Idle *IdleState `json:"idle"`
+		}{state.Idle})
+	})
+
+	mux.HandleFunc("/back", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			// Action is keep, discard or reassign, or empty to leave it to
+			// the configured policy.
+			Action string `json:"action"`
+			ID     string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch req.Action {
+		case "", "keep", "discard":
+		case "reassign":
+			if req.ID == "" {
+				http.Error(w, "reassign needs a task ID", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q: use keep, discard or reassign", req.Action), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		idle := state.Idle
+		if idle == nil {
+			http.Error(w, "you're not away", http.StatusBadRequest)
+			return
+		}
+		lastActivity = time.Now().UTC()
+		var resuming *Task
+		if idle.Returned == nil {
+			wakeUp(lastActivity)
+			resuming = topCopy(state.CurrentStack())
+		}
+
+		var task *Task
+		if req.Action != "" && state.Idle != nil {
+			var err error
+			if task, err = resolveIdle(req.Action, req.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
+
+		// Idle is still set if the time away is waiting for a decision.
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Away     IdleState  `json:"away"`
+			Action   string     `json:"action,omitempty"`
+			Task     *Task      `json:"task,omitempty"`
+			Idle     *IdleState `json:"idle"`
+			Resuming *Task      `json:"resuming,omitempty"`
+			Revision int64      `json:"revision"`
+		}{*idle, req.Action, task, state.Idle, resuming, state.CurrentStack().Revision})
+	})
+
+	mux.HandleFunc("/focus", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			// Length is a Go duration string; it defaults to 25m.
+			Length string `json:"length"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		length := defaultFocusLength
+		if req.Length != "" {
+			var err error
+			if length, err = parseFocusLength(req.Length); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			if conflict(w, r, state.Current) {
+				return
+			}
+			now := time.Now().UTC()
+			if f := state.Focus; f != nil {
+				http.Error(w, fmt.Sprintf("already focusing on %s (%s left)", f.Task, formatDuration(f.Remaining(now))), http.StatusConflict)
+				return
+			}
+			top := state.CurrentStack().Peek()
+			if top == nil {
+				http.Error(w, "stack is empty", http.StatusBadRequest)
+				return
+			}
+			state.Focus = &FocusSession{Context: state.Current, TaskID: top.ID, Task: top.Label(), Start: now, End: now.Add(length)}
+			store.SaveState(state)
+			scheduleFocus()
+			bus.Publish(Event{Type: "focus-started", Context: state.Current, Task: topCopy(state.CurrentStack()), At: now})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Focus *FocusSession `json:"focus"`
+		}{state.Focus})
+	})
+
+	mux.HandleFunc("/focus/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		session := state.Focus
+		if session == nil || session.Break {
+			http.Error(w, "not focusing", http.StatusBadRequest)
+			return
+		}
+		endFocus(time.Now().UTC())
+		scheduleFocus()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Stopped FocusSession `json:"stopped"`
+		}{*session})
+	})
+
+	mux.HandleFunc("/break", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			// Length is a Go duration string; it defaults to 5m.
+			Length string `json:"length"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		length := defaultBreakLength
+		if req.Length != "" {
+			var err error
+			if length, err = parseFocusLength(req.Length); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if conflict(w, r, state.Current) {
+			return
+		}
+		if state.Idle != nil && state.Idle.Returned == nil {
+			http.Error(w, "you're away; use memo back first", http.StatusConflict)
+			return
+		}
+		now := time.Now().UTC()
+		// A break cuts short a focus session, or restarts a break.
+		if state.Focus != nil {
+			endFocus(now)
+		}
+		ctx, stack := state.Current, state.CurrentStack()
+		if top := stack.Peek(); top != nil && top.Running() {
+			LogTaskStop(store, ctx, *top, now, "paused")
+			top.Pause(now)
+		}
+		stack.Revision++
+		state.Focus = &FocusSession{Break: true, Context: ctx, Start: now, End: now.Add(length)}
+		store.SaveState(state)
+		scheduleFocus()
+		bus.Publish(Event{Type: "break-started", Context: ctx, Task: topCopy(stack), At: now})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Focus    *FocusSession `json:"focus"`
+			Paused   *Task         `json:"paused,omitempty"`
+			Revision int64         `json:"revision"`
+		}{state.Focus, stack.Peek(), stack.Revision})
+	})
+
+	mux.HandleFunc("/break/end", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		session := state.Focus
+		if session == nil || !session.Break {
+			http.Error(w, "not on a break", http.StatusBadRequest)
+			return
+		}
+		endFocus(time.Now().UTC())
+		scheduleFocus()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Ended    FocusSession `json:"ended"`
+			Resuming *Task        `json:"resuming,omitempty"`
+			Revision int64        `json:"revision"`
+		}{*session, state.CurrentStack().Peek(), state.CurrentStack().Revision})
+	})
+
+	// Reload the config on SIGHUP. The data directory and socket only
+	// change when the daemon restarts, so the paths taken from them above
+	// are kept.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			if err := loadConfig(); err != nil {
+				log.Printf("failed to reload config: %v", err)
+				continue
+			}
+			settings, err := loadIdleSettings(config.Load())
+			if err != nil {
+				log.Printf("idle detection disabled: %v", err)
+			}
+			mu.Lock()
+			idleConfig = settings
+			mu.Unlock()
+		}
+	}()
+
+	// Handle signals for clean shutdown: closing the listener stops the
+	// server below, which cleans up.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sigCh
+		ln.Close()
+	}()
+
+	// Any change made through the daemon counts as activity, except those
+	// that start or end a spell away or a break themselves.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/idle", "/back", "/break", "/break/end":
+		default:
+			if r.Method == http.MethodPost {
+				if moved := activity(); len(moved) > 0 {
+					r = r.WithContext(context.WithValue(r.Context(), movedKey{}, moved))
+				}
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	server := &http.Server{Handler: withExpectedRevision(handler)}
+	err = server.Serve(ln)
+	os.Remove(sock)
+	os.Remove(pid)
+	if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+// revisionKey is the request context key for the stack revision a request
+// expects, as read by withExpectedRevision.
+type revisionKey struct{}
+
+// movedKey is the request context key for how far coming back or ending a
+// break moved each context's revision on before the request was handled.
+type movedKey struct{}
+
+// withExpectedRevision records the stack revision a request was made
+// against, from an If-Match header or an expected_revision field in its JSON
+// body, in the request's context. The body is left in place for the handler.
+func withExpectedRevision(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rev, ok, err := expectedRevision(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), revisionKey{}, rev))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func expectedRevision(r *http.Request) (int64, bool, error) {
+	if h := r.Header.Get("If-Match"); h != "" {
+		rev, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(h, "W/"), `"`), 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid If-Match %q", h)
+		}
+		return rev, true, nil
+	}
+	if r.Method != http.MethodPost || r.Body == nil {
+		return 0, false, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return 0, false, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	var req struct {
+		ExpectedRevision *int64 `json:"expected_revision"`
+	}
+	if json.Unmarshal(data, &req) != nil || req.ExpectedRevision == nil {
+		return 0, false, nil
+	}
+	return *req.ExpectedRevision, true, nil
+}
+
+// takeTask removes the task with the given ID, or the top task if id is
+// empty, and reports whether it was the top task.
+func takeTask(stack *TaskStack, id string) (*Task, bool) {
+	if id == "" {
+		return stack.Pop(), true
+	}
+	i, _ := stack.Find(id)
+	if i < 0 {
+		return nil, false
+	}
+	return stack.Remove(id), i == 0
+}
+
+func ensureDaemon() {
+	sock := socketPath()
+
+	// Try connecting to existing daemon
+	if tryConnect(sock) {
+		return
+	}
+
+	// Check PID file
+	if data, err := os.ReadFile(pidPath()); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			if process, err := os.FindProcess(pid); err == nil {
+				if err := process.Signal(syscall.Signal(0)); err == nil {
+					// Process exists, wait for socket
+					for i := 0; i < 20; i++ {
+						time.Sleep(100 * time.Millisecond)
+						if tryConnect(sock) {
+							return
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Start daemon
+	exe, err := os.Executable()
+	if err != nil {
+		fail(cliError{Exit: exitUnreachable, Code: "daemon_unreachable", Message: fmt.Sprintf("failed to find executable: %v", err)})
+	}
 
 	cmd := exec.Command(exe, "__daemon")
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
 	cmd.Stdout = nil
 	cmd.Stderr = nil
 	if err := cmd.Start(); err != nil {
-		log.Fatalf("failed to start daemon: %v", err)
+		fail(cliError{Exit: exitUnreachable, Code: "daemon_unreachable", Message: fmt.Sprintf("failed to start daemon: %v", err)})
 	}
 	cmd.Process.Release()
 
@@ -384,21 +1850,29 @@ func ensureDaemon() {
 			return
 		}
 	}
-	fmt.Fprintf(os.Stderr, "error: daemon did not start in time\n")
-	os.Exit(1)
+	fail(cliError{Exit: exitUnreachable, Code: "daemon_unreachable", Message: "daemon did not start in time"})
 }
 
-func killDaemon() {
+// daemonProcess returns the daemon process named by the PID file, or nil.
+func daemonProcess() *os.Process {
 	data, err := os.ReadFile(pidPath())
 	if err != nil {
-		return
+		return nil
 	}
 	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
 	if err != nil {
-		return
+		return nil
 	}
 	process, err := os.FindProcess(pid)
 	if err != nil {
+		return nil
+	}
+	return process
+}
+
+func killDaemon() {
+	process := daemonProcess()
+	if process == nil {
 		return
 	}
 	process.Signal(syscall.SIGTERM)
@@ -413,6 +1887,13 @@ func killDaemon() {
 	os.Remove(pidPath())
 }
 
+// reloadDaemon asks a running daemon to reload its config.
+func reloadDaemon() {
+	if process := daemonProcess(); process != nil {
+		process.Signal(syscall.SIGHUP)
+	}
+}
+
 func tryConnect(sock string) bool {
 	conn, err := net.DialTimeout("unix", sock, 200*time.Millisecond)
 	if err != nil {
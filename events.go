@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Event describes one change to a stack, as streamed from /events.
+type Event struct {
+	Type    string `json:"type"`
+	Context string `json:"context"`
+	Task    *Task  `json:"task,omitempty"`
+	// Previous and Next are the top tasks of the context before and after
+	// the change.
+	Previous *Task     `json:"previous,omitempty"`
+	Next     *Task     `json:"next,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// eventBus fans events out to every subscriber. Slow subscribers miss events
+// rather than holding up the daemon.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	queues []*eventQueue
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan Event]struct{})}
+}
+
+func (b *eventBus) Subscribe() chan Event {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Queue returns a subscription that never drops events, for consumers like
+// hooks that may fall behind but must see every event.
+func (b *eventBus) Queue() *eventQueue {
+	q := &eventQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	b.mu.Lock()
+	b.queues = append(b.queues, q)
+	b.mu.Unlock()
+	return q
+}
+
+func (b *eventBus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) Publish(events ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		for ch := range b.subs {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+		for _, q := range b.queues {
+			q.push(e)
+		}
+	}
+}
+
+// eventQueue is an unbounded queue of events.
+type eventQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []Event
+}
+
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	q.events = append(q.events, e)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// Next waits for the oldest event not yet taken and returns it.
+func (q *eventQueue) Next() Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.events) == 0 {
+		q.cond.Wait()
+	}
+	e := q.events[0]
+	q.events[0] = Event{}
+	q.events = q.events[1:]
+	return e
+}
+
+// diffEvents describes the change an operation made to a context's stack.
+// Tasks that left the stack are "completed" if the operation was a pop and
+// "dropped" otherwise; tasks added by a queue, or by an insert or import
+// that didn't start them, are "queued"; tasks whose description, tags or
+// project changed are "edited". Top changes are reported as "paused" and
+// "started" only in the current context, since other contexts' tasks never
+// run.
+func diffEvents(ctx, op string, before, after *TaskStack, current bool) []Event {
+	now := time.Now().UTC()
+	prev, next := topCopy(before), topCopy(after)
+	event := func(typ string, t Task) Event {
+		t = t.Copy()
+		return Event{Type: typ, Context: ctx, Task: &t, Previous: prev, Next: next, At: now}
+	}
+
+	var events []Event
+	for _, t := range before.Tasks {
+		if i, _ := after.Find(t.ID); i < 0 {
+			typ := "dropped"
+			if op == "pop" {
+				typ = "completed"
+			}
+			events = append(events, event(typ, t))
+		}
+	}
+	for _, t := range after.Tasks {
+		if _, old := before.Find(t.ID); old != nil && old.Label() != t.Label() {
+			events = append(events, event("edited", t))
+		}
+	}
+	topChanged := (prev == nil) != (next == nil) || (prev != nil && prev.ID != next.ID)
+	if op == "queue" || op == "insert" || op == "import" {
+		for j, t := range after.Tasks {
+			started := op != "queue" && j == 0 && topChanged && current
+			if i, _ := before.Find(t.ID); i < 0 && !started {
+				events = append(events, event("queued", t))
+			}
+		}
+	}
+
+	if topChanged && current {
+		if prev != nil {
+			if _, t := after.Find(prev.ID); t != nil {
+				events = append(events, event("paused", *t))
+			}
+		}
+		if next != nil {
+			events = append(events, event("started", *next))
+		}
+	} else if orderChanged(before, after) {
+		events = append(events, Event{Type: "reordered", Context: ctx, Previous: prev, Next: next, At: now})
+	}
+	return events
+}
+
+// topCopy returns a copy of the stack's top task, safe to hand to other
+// goroutines, or nil if the stack is empty.
+func topCopy(s *TaskStack) *Task {
+	top := s.Peek()
+	if top == nil {
+		return nil
+	}
+	t := top.Copy()
+	return &t
+}
+
+// orderChanged reports whether the tasks on both stacks appear in a
+// different relative order.
+func orderChanged(before, after *TaskStack) bool {
+	var a, b []string
+	for _, t := range before.Tasks {
+		if i, _ := after.Find(t.ID); i >= 0 {
+			a = append(a, t.ID)
+		}
+	}
+	for _, t := range after.Tasks {
+		if i, _ := before.Find(t.ID); i >= 0 {
+			b = append(b, t.ID)
+		}
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return true
+		}
+	}
+	return false
+}
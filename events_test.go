@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffEvents(t *testing.T) {
+	task := func(id, description string) Task {
+		return Task{ID: id, Description: description}
+	}
+	a, b, c, d := task("aaaaaa", "a"), task("bbbbbb", "b"), task("cccccc", "c"), task("dddddd", "d")
+	stack := func(tasks ...Task) *TaskStack { return &TaskStack{Tasks: tasks} }
+
+	tests := []struct {
+		name          string
+		op            string
+		before, after *TaskStack
+		notCurrent    bool
+		want          []string
+	}{
+		{
+			name:   "push onto an empty stack",
+			op:     "push",
+			before: stack(),
+			after:  stack(b),
+			want:   []string{"started b"},
+		},
+		{
+			name:   "push pauses the old top",
+			op:     "push",
+			before: stack(a),
+			after:  stack(b, a),
+			want:   []string{"paused a", "started b"},
+		},
+		{
+			// Other contexts' tasks never run, so there's nothing to say.
+			name:       "push in another context",
+			op:         "push",
+			before:     stack(a),
+			after:      stack(b, a),
+			notCurrent: true,
+		},
+		{
+			name:   "pop completes the top",
+			op:     "pop",
+			before: stack(b, a),
+			after:  stack(a),
+			want:   []string{"completed b", "started a"},
+		},
+		{
+			name:   "pop the last task",
+			op:     "pop",
+			before: stack(a),
+			after:  stack(),
+			want:   []string{"completed a"},
+		},
+		{
+			name:   "drop further down",
+			op:     "drop",
+			before: stack(b, a),
+			after:  stack(b),
+			want:   []string{"dropped a"},
+		},
+		{
+			name:   "queue",
+			op:     "queue",
+			before: stack(a),
+			after:  stack(a, c),
+			want:   []string{"queued c"},
+		},
+		{
+			name:   "queue onto an empty stack starts the task",
+			op:     "queue",
+			before: stack(),
+			after:  stack(c),
+			want:   []string{"queued c", "started c"},
+		},
+		{
+			name:   "insert on top",
+			op:     "insert",
+			before: stack(a),
+			after:  stack(c, a),
+			want:   []string{"paused a", "started c"},
+		},
+		{
+			name:   "insert further down",
+			op:     "insert",
+			before: stack(a, b),
+			after:  stack(a, c, b),
+			want:   []string{"queued c"},
+		},
+		{
+			name:   "import with push starts the first",
+			op:     "import",
+			before: stack(a),
+			after:  stack(c, d, a),
+			want:   []string{"queued d", "paused a", "started c"},
+		},
+		{
+			name:   "import at the bottom",
+			op:     "import",
+			before: stack(a),
+			after:  stack(a, c, d),
+			want:   []string{"queued c", "queued d"},
+		},
+		{
+			name:       "import in another context queues everything",
+			op:         "import",
+			before:     stack(a),
+			after:      stack(c, a),
+			notCurrent: true,
+			want:       []string{"queued c"},
+		},
+		{
+			name:   "edit",
+			op:     "edit",
+			before: stack(a, b),
+			after:  stack(task("aaaaaa", "a, properly"), b),
+			want:   []string{"edited a, properly"},
+		},
+		{
+			name:   "tagging counts as an edit",
+			op:     "edit",
+			before: stack(a),
+			after:  stack(Task{ID: "aaaaaa", Description: "a", Tags: []string{"bug"}}),
+			want:   []string{"edited a"},
+		},
+		{
+			name:   "reorder under the top",
+			op:     "reorder",
+			before: stack(a, b, c),
+			after:  stack(a, c, b),
+			want:   []string{"reordered"},
+		},
+		{
+			name:   "reorder to a new top",
+			op:     "reorder",
+			before: stack(a, b, c),
+			after:  stack(b, a, c),
+			want:   []string{"paused a", "started b"},
+		},
+		{
+			name:       "reorder in another context",
+			op:         "reorder",
+			before:     stack(a, b),
+			after:      stack(b, a),
+			notCurrent: true,
+			want:       []string{"reordered"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := diffEvents("work", tt.op, tt.before, tt.after, !tt.notCurrent)
+			var got []string
+			for _, e := range events {
+				if e.Context != "work" {
+					t.Errorf("%s event in context %q, want work", e.Type, e.Context)
+				}
+				if !reflect.DeepEqual(e.Previous, topCopy(tt.before)) || !reflect.DeepEqual(e.Next, topCopy(tt.after)) {
+					t.Errorf("%s event tops = %v, %v, want the tops before and after", e.Type, e.Previous, e.Next)
+				}
+				if e.Task == nil {
+					got = append(got, e.Type)
+					continue
+				}
+				got = append(got, e.Type+" "+e.Task.Description)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffEvents() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventBusQueueKeepsEveryEvent(t *testing.T) {
+	bus := newEventBus()
+	q := bus.Queue()
+	sub := bus.Subscribe()
+
+	const n = 200
+	for i := range n {
+		bus.Publish(Event{Type: fmt.Sprint(i)})
+	}
+	for i := range n {
+		if e := q.Next(); e.Type != fmt.Sprint(i) {
+			t.Fatalf("event %d from the queue = %s, want %d", i, e.Type, i)
+		}
+	}
+	// Subscribers that fall behind miss events instead.
+	if len(sub) != cap(sub) {
+		t.Errorf("subscriber has %d events, want a full buffer of %d", len(sub), cap(sub))
+	}
+}
+
+func TestEventQueueNextWaits(t *testing.T) {
+	bus := newEventBus()
+	q := bus.Queue()
+	got := make(chan Event)
+	go func() { got <- q.Next() }()
+
+	select {
+	case e := <-got:
+		t.Fatalf("Next() = %+v before anything was published", e)
+	case <-time.After(20 * time.Millisecond):
+	}
+	bus.Publish(Event{Type: "started"})
+	select {
+	case e := <-got:
+		if e.Type != "started" {
+			t.Errorf("Next() = %+v, want the started event", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next() didn't return after an event was published")
+	}
+}
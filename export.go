@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportFormats = []string{"csv", "ical", "json", "markdown"}
+
+// Export prints the work sessions between filter.Since and filter.Until in
+// the given format. Times are stored in UTC; CSV, JSON and Markdown show
+// them in the local time zone with its offset, and iCalendar in UTC.
+func (c *memoClient) Export(filter LogFilter, format string) {
+	// As with reports, sessions running past either bound are clipped to it.
+	since, until := filter.Since, filter.Until
+	filter.Until = time.Time{}
+	filter.Effective = true
+	ss := sessions(c.fetchLog(filter), since, until)
+	for i := range ss {
+		ss[i].start, ss[i].end = ss[i].start.Local(), ss[i].end.Local()
+	}
+
+	var err error
+	switch format {
+	case "csv":
+		err = writeCSV(os.Stdout, ss)
+	case "ical":
+		err = writeICal(os.Stdout, ss, time.Now())
+	case "json":
+		printJSON(sessionsJSON(ss))
+	case "markdown":
+		writeTimesheet(os.Stdout, ss)
+	}
+	if err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: fmt.Sprintf("export failed: %v", err)})
+	}
+}
+
+type sessionOutput struct {
+	ID      string   `json:"id,omitempty"`
+	Context string   `json:"context"`
+	Task    string   `json:"task"`
+	Tags    []string `json:"tags,omitempty"`
+	Project string   `json:"project,omitempty"`
+	Start   string   `json:"start"`
+	End     string   `json:"end"`
+	Seconds int64    `json:"seconds"`
+	Reason  string   `json:"reason"`
+}
+
+func sessionsJSON(ss []session) []sessionOutput {
+	out := []sessionOutput{}
+	for _, s := range ss {
+		out = append(out, sessionOutput{
+			ID:      s.entry.ID,
+			Context: s.entry.ContextName(),
+			Task:    s.entry.Task,
+			Tags:    s.entry.Tags,
+			Project: s.entry.Project,
+			Start:   s.start.Format(time.RFC3339),
+			End:     s.end.Format(time.RFC3339),
+			Seconds: int64(s.end.Sub(s.start).Seconds()),
+			Reason:  s.entry.Reason,
+		})
+	}
+	return out
+}
+
+// writeCSV writes a row per session, with decimal hours for spreadsheets.
+func writeCSV(w io.Writer, ss []session) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "start", "end", "hours", "task", "project", "tags", "context", "id", "reason"})
+	for _, s := range ss {
+		cw.Write([]string{
+			s.start.Format("2006-01-02"),
+			s.start.Format(time.RFC3339),
+			s.end.Format(time.RFC3339),
+			strconv.FormatFloat(s.end.Sub(s.start).Hours(), 'f', 2, 64),
+			s.entry.Task,
+			s.entry.Project,
+			strings.Join(s.entry.Tags, ","),
+			s.entry.ContextName(),
+			s.entry.ID,
+			s.entry.Reason,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeICal writes a VEVENT per session, stamped with now.
+func writeICal(w io.Writer, ss []session, now time.Time) error {
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	line := func(name, value string) {
+		b.WriteString(foldICal(name + ":" + value))
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//memo//memo "+Version+"//EN")
+	line("CALSCALE", "GREGORIAN")
+	for _, s := range ss {
+		description := []string{"Context: " + s.entry.ContextName()}
+		if s.entry.Project != "" {
+			description = append(description, "Project: "+s.entry.Project)
+		}
+		description = append(description, "Ended: "+s.entry.Reason)
+
+		line("BEGIN", "VEVENT")
+		line("UID", fmt.Sprintf("%s-%d@memo", s.entry.ID, s.start.Unix()))
+		line("DTSTAMP", now.UTC().Format(stamp))
+		line("DTSTART", s.start.UTC().Format(stamp))
+		line("DTEND", s.end.UTC().Format(stamp))
+		line("SUMMARY", escapeICal(s.entry.Task))
+		if len(s.entry.Tags) > 0 {
+			tags := make([]string, len(s.entry.Tags))
+			for i, tag := range s.entry.Tags {
+				tags[i] = escapeICal(tag)
+			}
+			line("CATEGORIES", strings.Join(tags, ","))
+		}
+		line("DESCRIPTION", escapeICal(strings.Join(description, "\n")))
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeICal escapes a TEXT value as RFC 5545 requires.
+func escapeICal(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// foldICal ends a content line with CRLF, folding it so no line is longer
+// than 75 octets without splitting a UTF-8 sequence.
+func foldICal(s string) string {
+	var b strings.Builder
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74
+	}
+	b.WriteString(s + "\r\n")
+	return b.String()
+}
+
+// writeTimesheet writes a Markdown table per day, splitting sessions at
+// midnight, with the day's total and a grand total.
+func writeTimesheet(w io.Writer, ss []session) {
+	if len(ss) == 0 {
+		fmt.Fprintln(w, "No time logged in this period.")
+		return
+	}
+	var days []string
+	byDay := map[string][]session{}
+	for _, s := range ss {
+		for _, part := range splitDays(s) {
+			day := part.start.Format("2006-01-02")
+			if _, ok := byDay[day]; !ok {
+				days = append(days, day)
+			}
+			byDay[day] = append(byDay[day], part)
+		}
+	}
+	slices.Sort(days)
+
+	cell := func(s string) string { return strings.ReplaceAll(s, "|", `\|`) }
+	var total time.Duration
+	for i, day := range days {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		parts := byDay[day]
+		slices.SortStableFunc(parts, func(a, b session) int { return a.start.Compare(b.start) })
+		fmt.Fprintf(w, "## %s\n\n", parts[0].start.Format("Monday, 2 January 2006"))
+		fmt.Fprintln(w, "| Start | End | Task | Project | Tags | Time |")
+		fmt.Fprintln(w, "|---|---|---|---|---|---:|")
+		var dayTotal time.Duration
+		for _, p := range parts {
+			d := p.end.Sub(p.start)
+			dayTotal += d
+			tags := make([]string, len(p.entry.Tags))
+			for i, tag := range p.entry.Tags {
+				tags[i] = "+" + tag
+			}
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n",
+				formatClock(p.start), formatClock(p.end), cell(p.entry.Task), cell(p.entry.Project), cell(strings.Join(tags, " ")), formatDuration(d))
+		}
+		fmt.Fprintf(w, "| | | **Total** | | | **%s** |\n", formatDuration(dayTotal))
+		total += dayTotal
+	}
+	fmt.Fprintf(w, "\n**Total: %s** (%s)\n", formatDuration(total), zoneName(ss[0].start))
+}
+
+// zoneName names the time zone of t, as in "CET, UTC+01:00".
+func zoneName(t time.Time) string {
+	name, _ := t.Zone()
+	return name + ", UTC" + t.Format("-07:00")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeICal(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"fix auth bug", "fix auth bug"},
+		{"a, b; c", `a\, b\; c`},
+		{`C:\memo`, `C:\\memo`},
+		{"two\nlines", `two\nlines`},
+		{`\,`, `\\\,`},
+		{"colons: stay", "colons: stay"},
+	}
+	for _, tt := range tests {
+		if got := escapeICal(tt.in); got != tt.want {
+			t.Errorf("escapeICal(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFoldICal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "short line",
+			in:   "SUMMARY:fix auth bug",
+			want: "SUMMARY:fix auth bug\r\n",
+		},
+		{
+			name: "exactly 75 octets",
+			in:   strings.Repeat("a", 75),
+			want: strings.Repeat("a", 75) + "\r\n",
+		},
+		{
+			name: "76 octets",
+			in:   strings.Repeat("a", 76),
+			want: strings.Repeat("a", 75) + "\r\n a\r\n",
+		},
+		{
+			name: "continuation lines hold 74 octets after the space",
+			in:   strings.Repeat("a", 75+74+1),
+			want: strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n",
+		},
+		{
+			name: "doesn't split a UTF-8 sequence",
+			// 74 ASCII bytes then "é" (2 bytes) straddles the 75-octet limit.
+			in:   strings.Repeat("a", 74) + "é",
+			want: strings.Repeat("a", 74) + "\r\n é\r\n",
+		},
+		{
+			name: "doesn't split a 4-byte sequence",
+			in:   strings.Repeat("a", 73) + "😀b",
+			want: strings.Repeat("a", 73) + "\r\n 😀b\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := foldICal(tt.in)
+			if got != tt.want {
+				t.Errorf("foldICal() = %q, want %q", got, tt.want)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+				if len(line) > 75 {
+					t.Errorf("line of %d octets: %q", len(line), line)
+				}
+			}
+			// Unfolding gives back the original line.
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(got, "\r\n"), "\r\n ", ""); unfolded != tt.in {
+				t.Errorf("unfolds to %q, want %q", unfolded, tt.in)
+			}
+		})
+	}
+}
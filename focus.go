@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultFocusLength = 25 * time.Minute
+	defaultBreakLength = 5 * time.Minute
+)
+
+// FocusSession is a timed focus session on the top task, or a break between
+// sessions. A session that runs its full length is logged as "focus" and
+// counts as a pomodoro for its task; a break is logged as "break" and isn't
+// counted as time on any task.
+type FocusSession struct {
+	Break   bool      `json:"break,omitempty"`
+	Context string    `json:"context"`
+	TaskID  string    `json:"task_id,omitempty"`
+	Task    string    `json:"task,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// Remaining returns the time left in the session at now.
+func (f *FocusSession) Remaining(now time.Time) time.Duration {
+	return max(f.End.Sub(now), 0)
+}
+
+// Length returns how long the session was set to run.
+func (f *FocusSession) Length() time.Duration {
+	return f.End.Sub(f.Start)
+}
+
+// parseFocusLength parses the length of a focus session or break: a duration
+// like 25m or 1h, or a number of minutes.
+func parseFocusLength(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		s = strconv.Itoa(n) + "m"
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid length %q: use minutes or a duration like 25m", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hookNames maps event types to the hook script run for them.
+var hookNames = map[string]string{
+	"started":       "on-start",
+	"paused":        "on-pause",
+	"completed":     "on-pop",
+	"dropped":       "on-drop",
+	"queued":        "on-queue",
+	"reordered":     "on-reorder",
+	"edited":        "on-edit",
+	"idle":          "on-idle",
+	"back":          "on-back",
+	"focus-started": "on-focus",
+	"focus-ended":   "on-focus-end",
+	"break-started": "on-break",
+	"break-ended":   "on-break-end",
+}
+
+// hookEvent returns the event type for a hook given as "on-start", "start"
+// or "started".
+func hookEvent(name string) (string, bool) {
+	for typ, hook := range hookNames {
+		if name == typ || name == hook || "on-"+name == hook {
+			return typ, true
+		}
+	}
+	return "", false
+}
+
+// runHooks runs the hook for each event from the queue in order, logging
+// failures to hooks.log in dataDir.
+func runHooks(events *eventQueue, dataDir string) {
+	for {
+		e := events.Next()
+		if _, err := runHook(hooksDirIn(dataDir), e, nil); err != nil {
+			logHookFailure(hooksLogPath(dataDir), err)
+		}
+	}
+}
+
+// runHook runs the hook script for e, if there is one, with the event as JSON
+// on stdin and its fields in the environment. It returns the script's
+// combined output.
+func runHook(dir string, e Event, extraEnv []string) ([]byte, error) {
+	name, ok := hookNames[e.Type]
+	if !ok {
+		return nil, nil
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("%s is not executable", path)
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := config.Load().HookTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(append(os.Environ(), hookEnv(e)...), extraEnv...)
+	out, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return out, fmt.Errorf("%s failed: %v: %s", name, err, msg)
+		}
+		return out, fmt.Errorf("%s failed: %v", name, err)
+	}
+	return out, nil
+}
+
+func hookEnv(e Event) []string {
+	env := []string{
+		"MEMO_EVENT=" + e.Type,
+		"MEMO_TASK_CONTEXT=" + e.Context,
+	}
+	tasks := []struct {
+		prefix string
+		task   *Task
+	}{
+		{"MEMO_TASK", e.Task},
+		{"MEMO_PREV_TASK", e.Previous},
+		{"MEMO_NEXT_TASK", e.Next},
+	}
+	for _, t := range tasks {
+		if t.task != nil {
+			env = append(env, t.prefix+"="+t.task.Description, t.prefix+"_ID="+t.task.ID)
+		}
+	}
+	return env
+}
+
+func logHookFailure(path string, err error) {
+	f, ferr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "[%s] %v\n", time.Now().Format(time.RFC3339), err)
+}
+
+// listHooks prints every hook and whether it is installed.
+func listHooks() {
+	dir := hooksDir()
+	names := make([]string, 0, len(hookNames))
+	for _, name := range hookNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status := "not installed"
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			if info.Mode()&0111 == 0 {
+				status = "not executable"
+			} else {
+				status = "installed"
+			}
+		}
+		fmt.Printf("%-13s %s\n", name, status)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idleCheckInterval is how often the daemon checks for idleness.
+const idleCheckInterval = 15 * time.Second
+
+// IdleState records a spell away from the keyboard. Since is when activity
+// stopped and the top task was paused. Returned is set once activity
+// resumed, while the away time waits to be kept, discarded or reassigned.
+type IdleState struct {
+	Since    time.Time  `json:"since"`
+	Returned *time.Time `json:"returned,omitempty"`
+	Context  string     `json:"context"`
+	TaskID   string     `json:"task_id"`
+	Task     string     `json:"task"`
+	// Manual is set by memo idle. Only a change to the stack or memo back
+	// ends a manual spell, not the idle probe.
+	Manual bool `json:"manual,omitempty"`
+}
+
+// Away returns how long the spell lasted, or has lasted so far.
+func (s *IdleState) Away(now time.Time) time.Duration {
+	if s.Returned != nil {
+		now = *s.Returned
+	}
+	return now.Sub(s.Since)
+}
+
+// idleSettings controls idle detection.
+type idleSettings struct {
+	// After is how long without activity counts as idle; 0 turns idle
+	// detection off.
+	After time.Duration
+	// Return is what happens to the away time on coming back: ask, keep or
+	// discard.
+	Return string
+	// Probe reports how long the system has been idle, or nil if only
+	// memo commands count as activity.
+	Probe idleProbe
+}
+
+var idleReturns = []string{"ask", "keep", "discard"}
+
+// loadIdleSettings reads idle settings from cfg, overridden by
+// MEMO_IDLE_AFTER, MEMO_IDLE_RETURN and MEMO_IDLE_PROBE if they're set.
+func loadIdleSettings(cfg *Config) (idleSettings, error) {
+	s := idleSettings{After: cfg.IdleAfter, Return: cfg.IdleReturn}
+	if v := os.Getenv("MEMO_IDLE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return s, fmt.Errorf("invalid MEMO_IDLE_AFTER %q", v)
+		}
+		s.After = d
+	}
+	if v := os.Getenv("MEMO_IDLE_RETURN"); v != "" {
+		if !slices.Contains(idleReturns, v) {
+			return s, fmt.Errorf("MEMO_IDLE_RETURN must be one of %s", strings.Join(idleReturns, ", "))
+		}
+		s.Return = v
+	}
+	probe := cfg.IdleProbe
+	if v := os.Getenv("MEMO_IDLE_PROBE"); v != "" {
+		probe = v
+	}
+	s.Probe = newIdleProbe(probe)
+	return s, nil
+}
+
+// idleProbe reports how long the user has been idle, as seen by the system.
+type idleProbe interface {
+	Idle() (time.Duration, error)
+}
+
+// newIdleProbe returns the probe for a MEMO_IDLE_PROBE setting: a command
+// printing idle milliseconds, "none", or empty to use xprintidle under X11
+// if it's installed.
+func newIdleProbe(setting string) idleProbe {
+	switch setting {
+	case "none":
+		return nil
+	case "":
+		if os.Getenv("DISPLAY") == "" {
+			return nil
+		}
+		if _, err := exec.LookPath("xprintidle"); err != nil {
+			return nil
+		}
+		return commandProbe{"xprintidle"}
+	}
+	return commandProbe(strings.Fields(setting))
+}
+
+// commandProbe runs a command, such as xprintidle, that prints the idle time
+// in milliseconds.
+type commandProbe []string
+
+func (p commandProbe) Idle() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, p[0], p[1:]...).Output()
+	if err != nil {
+		return 0, err
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s printed %q, not milliseconds", p[0], strings.TrimSpace(string(out)))
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
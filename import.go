@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var importFormats = []string{"todotxt", "taskwarrior-json", "lines"}
+
+// importedTask is a task read from another tool's list. Lower ranks are more
+// urgent; tasks without a priority rank last.
+type importedTask struct {
+	Description string   `json:"description"`
+	Tags        []string `json:"tags,omitempty"`
+	Project     string   `json:"project,omitempty"`
+	rank        int
+}
+
+const noPriority = 1 << 10
+
+// parseImport reads tasks in the given format, most urgent first and
+// otherwise in the order they're listed.
+func parseImport(r io.Reader, format string) ([]importedTask, error) {
+	var tasks []importedTask
+	var err error
+	switch format {
+	case "todotxt":
+		tasks, err = parseTodoTxt(r)
+	case "taskwarrior-json":
+		tasks, err = parseTaskwarrior(r)
+	case "lines":
+		tasks, err = parseLines(r)
+	}
+	slices.SortStableFunc(tasks, func(a, b importedTask) int { return a.rank - b.rank })
+	return tasks, err
+}
+
+var (
+	todoDone     = regexp.MustCompile(`^x\s`)
+	todoPriority = regexp.MustCompile(`^\(([A-Z])\)\s+`)
+	todoDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\s+`)
+)
+
+// parseTodoTxt reads a todo.txt file, skipping completed tasks. A priority
+// (A) becomes the tag pri-a, the first +project the task's project, and
+// @contexts and any other +projects become tags.
+func parseTodoTxt(r io.Reader) ([]importedTask, error) {
+	var tasks []importedTask
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || todoDone.MatchString(line) {
+			continue
+		}
+		t := importedTask{rank: noPriority}
+		if m := todoPriority.FindStringSubmatch(line); m != nil {
+			t.rank = int(m[1][0] - 'A')
+			t.Tags = append(t.Tags, "pri-"+strings.ToLower(m[1]))
+			line = line[len(m[0]):]
+		}
+		line = todoDate.ReplaceAllString(line, "")
+
+		var words []string
+		for _, word := range strings.Fields(line) {
+			switch {
+			case len(word) > 1 && word[0] == '+' && t.Project == "":
+				t.Project = word[1:]
+			case len(word) > 1 && (word[0] == '+' || word[0] == '@'):
+				t.Tags = addTags(t.Tags, word[1:])
+			default:
+				words = append(words, word)
+			}
+		}
+		t.Description = strings.Join(words, " ")
+		if t.Description != "" {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, sc.Err()
+}
+
+// taskwarriorRanks orders Taskwarrior's priorities.
+var taskwarriorRanks = map[string]int{"H": 0, "M": 1, "L": 2}
+
+// parseTaskwarrior reads the output of task export: a JSON array of tasks,
+// or one task per line. Completed and deleted tasks are skipped. Priorities
+// H, M and L become the tags pri-h, pri-m and pri-l.
+func parseTaskwarrior(r io.Reader) ([]importedTask, error) {
+	type twTask struct {
+		Description string   `json:"description"`
+		Project     string   `json:"project"`
+		Tags        []string `json:"tags"`
+		Priority    string   `json:"priority"`
+		Status      string   `json:"status"`
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var in []twTask
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &in); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var t twTask
+			if err := dec.Decode(&t); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			in = append(in, t)
+		}
+	}
+
+	var tasks []importedTask
+	for _, tw := range in {
+		if tw.Status == "completed" || tw.Status == "deleted" || strings.TrimSpace(tw.Description) == "" {
+			continue
+		}
+		t := importedTask{Description: strings.TrimSpace(tw.Description), Project: tw.Project, rank: noPriority}
+		if rank, ok := taskwarriorRanks[tw.Priority]; ok {
+			t.rank = rank
+			t.Tags = append(t.Tags, "pri-"+strings.ToLower(tw.Priority))
+		}
+		t.Tags = addTags(t.Tags, tw.Tags...)
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+// parseLines reads a task per line, written as for memo push, skipping
+// blank lines and lines starting with #.
+func parseLines(r io.Reader) ([]importedTask, error) {
+	var tasks []importedTask
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tasks = append(tasks, importedTask{Description: line, rank: noPriority})
+	}
+	return tasks, sc.Err()
+}
+
+// Import adds the tasks listed in path ("-" for standard input) to the stack
+// in one change, at the bottom or, with push, on top with the first of them
+// current. Tasks already on the stack, and repeats within the list, are
+// skipped.
+func (c *memoClient) Import(path, format string, push bool) {
+	in := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fail(cliError{Exit: exitError, Code: "error", Message: err.Error()})
+		}
+		defer f.Close()
+		in = f
+	}
+	tasks, err := parseImport(in, format)
+	if err != nil {
+		fail(cliError{Exit: exitBadRequest, Code: "bad_import", Message: fmt.Sprintf("can't read %s as %s: %v", path, format, err)})
+	}
+
+	var result struct {
+		Imported   []Task   `json:"imported"`
+		Duplicates []Task   `json:"duplicates"`
+		Repeats    []string `json:"repeats"`
+	}
+	body := map[string]any{"tasks": tasks, "push": push}
+	if err := c.call("POST", "/import", body, &result); err != nil {
+		failErr(err)
+	}
+
+	if jsonOutput {
+		now := time.Now()
+		printJSON(struct {
+			Imported   []*taskOutput `json:"imported"`
+			Duplicates []*taskOutput `json:"duplicates"`
+			Repeats    []string      `json:"repeats"`
+		}{tasksJSON(result.Imported, now), tasksJSON(result.Duplicates, now), result.Repeats})
+		return
+	}
+	fmt.Printf("Imported %s.\n", plural(len(result.Imported), "task"))
+	if len(result.Duplicates) > 0 {
+		fmt.Printf("Skipped %d already on the stack:\n", len(result.Duplicates))
+		for _, t := range result.Duplicates {
+			fmt.Printf("  %s %s\n", t.ID, t.Label())
+		}
+	}
+	if len(result.Repeats) > 0 {
+		fmt.Printf("Skipped %d listed more than once:\n", len(result.Repeats))
+		for _, label := range result.Repeats {
+			fmt.Printf("  %s\n", label)
+		}
+	}
+}
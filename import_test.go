@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTodoTxt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []importedTask
+	}{
+		{
+			name: "plain task",
+			text: "call the plumber\n",
+			want: []importedTask{{Description: "call the plumber", rank: noPriority}},
+		},
+		{
+			name: "blank lines and completed tasks are skipped",
+			text: "\nx 2026-10-02 2026-10-01 old thing +house\n   \nx done too\nnext\n",
+			want: []importedTask{{Description: "next", rank: noPriority}},
+		},
+		{
+			name: "priority becomes a tag and a rank",
+			text: "(B) call plumber\n",
+			want: []importedTask{{Description: "call plumber", Tags: []string{"pri-b"}, rank: 1}},
+		},
+		{
+			name: "creation date is dropped",
+			text: "(A) 2026-10-01 fix auth bug\n2026-09-30 write report\n",
+			want: []importedTask{
+				{Description: "fix auth bug", Tags: []string{"pri-a"}, rank: 0},
+				{Description: "write report", rank: noPriority},
+			},
+		},
+		{
+			name: "first project is the project, contexts and other projects are tags",
+			text: "fix auth bug +memo +security @laptop @work\n",
+			want: []importedTask{{Description: "fix auth bug", Tags: []string{"security", "laptop", "work"}, Project: "memo", rank: noPriority}},
+		},
+		{
+			name: "key:value pairs and lone markers stay in the description",
+			text: "write report due:2026-10-20 + @ ok\n",
+			want: []importedTask{{Description: "write report due:2026-10-20 + @ ok", rank: noPriority}},
+		},
+		{
+			name: "repeated contexts are tagged once",
+			text: "phone mum @phone @phone\n",
+			want: []importedTask{{Description: "phone mum", Tags: []string{"phone"}, rank: noPriority}},
+		},
+		{
+			name: "lowercase x without a space and (a) aren't markers",
+			text: "xylophone lesson\n(a) lowercase\n",
+			want: []importedTask{
+				{Description: "xylophone lesson", rank: noPriority},
+				{Description: "(a) lowercase", rank: noPriority},
+			},
+		},
+		{
+			name: "a task with only markers is skipped",
+			text: "+memo @laptop\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTodoTxt(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatalf("parseTodoTxt() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTodoTxt() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTaskwarrior(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []importedTask
+		err  bool
+	}{
+		{
+			name: "array",
+			text: `[{"id":1,"description":"review PR","project":"memo","tags":["review","code"],"priority":"L","status":"pending","urgency":3.2},
+{"id":2,"description":"ship release","priority":"H","status":"pending"}]`,
+			want: []importedTask{
+				{Description: "review PR", Tags: []string{"pri-l", "review", "code"}, Project: "memo", rank: 2},
+				{Description: "ship release", Tags: []string{"pri-h"}, rank: 0},
+			},
+		},
+		{
+			name: "one object per line",
+			text: "{\"description\":\"a\",\"status\":\"pending\"}\n{\"description\":\"b\",\"priority\":\"M\",\"status\":\"waiting\"}\n",
+			want: []importedTask{
+				{Description: "a", rank: noPriority},
+				{Description: "b", Tags: []string{"pri-m"}, rank: 1},
+			},
+		},
+		{
+			name: "completed, deleted and empty tasks are skipped",
+			text: `[{"description":"done","status":"completed"},{"description":"gone","status":"deleted"},{"description":"  ","status":"pending"},{"description":" keep ","status":"pending"}]`,
+			want: []importedTask{{Description: "keep", rank: noPriority}},
+		},
+		{
+			name: "unknown priorities are ignored",
+			text: `[{"description":"x","priority":"urgent"}]`,
+			want: []importedTask{{Description: "x", rank: noPriority}},
+		},
+		{
+			name: "empty input",
+			text: "  \n",
+		},
+		{
+			name: "empty array",
+			text: "[]",
+		},
+		{name: "not JSON", text: "(A) call plumber", err: true},
+		{name: "broken array", text: `[{"description":"x"}`, err: true},
+		{name: "broken line", text: "{\"description\":\"a\"}\n{\"description\":\n", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTaskwarrior(strings.NewReader(tt.text))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parseTaskwarrior() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTaskwarrior() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTaskwarrior() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportOrdersByPriority(t *testing.T) {
+	text := "later\n(C) third\n(A) first\nalso later\n(A) second\n"
+	tasks, err := parseImport(strings.NewReader(text), "todotxt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, task := range tasks {
+		got = append(got, task.Description)
+	}
+	want := []string{"first", "second", "third", "later", "also later"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	text := "# seed list\n\nfix auth bug +bug @memo\n  write docs  \n"
+	got, err := parseLines(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []importedTask{
+		{Description: "fix auth bug +bug @memo", rank: noPriority},
+		{Description: "write docs", rank: noPriority},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLines() = %+v, want %+v", got, want)
+	}
+}
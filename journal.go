@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// journalDepth bounds how many mutations can be undone.
+const journalDepth = 50
+
+// JournalEntry records one stack mutation: the stack before and after it and
+// the log entries it wrote, so it can be undone and redone exactly.
+type JournalEntry struct {
+	Op      string     `json:"op"`
+	Context string     `json:"context,omitempty"`
+	Task    string     `json:"task"`
+	At      time.Time  `json:"at"`
+	Before  TaskStack  `json:"before"`
+	After   TaskStack  `json:"after"`
+	Logged  []LogEntry `json:"logged,omitempty"`
+}
+
+// ContextName returns the context the mutation happened in. Entries written
+// before contexts existed belong to the default context.
+func (e *JournalEntry) ContextName() string {
+	if e.Context == "" {
+		return defaultContext
+	}
+	return e.Context
+}
+
+type Journal struct {
+	Undo []JournalEntry `json:"undo"`
+	Redo []JournalEntry `json:"redo"`
+}
+
+// Record adds a mutation to the undo list and forgets anything that could
+// have been redone.
+func (j *Journal) Record(entry JournalEntry) {
+	j.Undo = append(j.Undo, entry)
+	if len(j.Undo) > journalDepth {
+		j.Undo = j.Undo[len(j.Undo)-journalDepth:]
+	}
+	j.Redo = nil
+}
+
+// PopUndo moves the most recent mutation onto the redo list and returns it.
+func (j *Journal) PopUndo() *JournalEntry {
+	if len(j.Undo) == 0 {
+		return nil
+	}
+	e := j.Undo[len(j.Undo)-1]
+	j.Undo = j.Undo[:len(j.Undo)-1]
+	j.Redo = append(j.Redo, e)
+	return &e
+}
+
+// PopRedo moves the most recently undone mutation back onto the undo list and
+// returns it.
+func (j *Journal) PopRedo() *JournalEntry {
+	if len(j.Redo) == 0 {
+		return nil
+	}
+	e := j.Redo[len(j.Redo)-1]
+	j.Redo = j.Redo[:len(j.Redo)-1]
+	j.Undo = append(j.Undo, e)
+	return &e
+}
+
+func SaveJournal(j *Journal, path string) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func LoadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Journal{}, nil
+		}
+		return nil, err
+	}
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replay runs steps against an empty stack, journaling each change the way
+// the daemon's commit does and putting stacks back the way undo and redo do.
+// It returns the descriptions left on the stack, top first.
+func replay(t *testing.T, j *Journal, steps []string) []string {
+	t.Helper()
+	stack := &TaskStack{Tasks: []Task{}}
+	for _, step := range steps {
+		op, arg, _ := strings.Cut(step, " ")
+		switch op {
+		case "undo":
+			if e := j.PopUndo(); e != nil {
+				stack = e.Before.Clone()
+			}
+			continue
+		case "redo":
+			if e := j.PopRedo(); e != nil {
+				stack = e.After.Clone()
+			}
+			continue
+		}
+
+		before := stack.Clone()
+		switch op {
+		case "push":
+			stack.Push(arg)
+		case "pop":
+			stack.Pop()
+		case "edit":
+			stack.Peek().Description = arg
+		default:
+			t.Fatalf("unknown step %q", step)
+		}
+		stack.Revision = before.Revision + 1
+		j.Record(JournalEntry{Op: op, Task: arg, Before: *before, After: *stack.Clone()})
+	}
+	descriptions := []string{}
+	for _, task := range stack.List() {
+		descriptions = append(descriptions, task.Description)
+	}
+	return descriptions
+}
+
+func TestJournalUndoRedo(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []string
+		want     []string
+		wantUndo int
+		wantRedo int
+	}{
+		{
+			name:  "nothing to undo or redo",
+			steps: []string{"undo", "redo"},
+			want:  []string{},
+		},
+		{
+			name:     "undo push",
+			steps:    []string{"push a", "push b", "undo"},
+			want:     []string{"a"},
+			wantUndo: 1,
+			wantRedo: 1,
+		},
+		{
+			name:     "undo pop",
+			steps:    []string{"push a", "push b", "pop", "undo"},
+			want:     []string{"b", "a"},
+			wantUndo: 2,
+			wantRedo: 1,
+		},
+		{
+			name:     "undo edit",
+			steps:    []string{"push a", "edit b", "undo"},
+			want:     []string{"a"},
+			wantUndo: 1,
+			wantRedo: 1,
+		},
+		{
+			name:     "redo after undo",
+			steps:    []string{"push a", "push b", "pop", "undo", "undo", "redo"},
+			want:     []string{"b", "a"},
+			wantUndo: 2,
+			wantRedo: 1,
+		},
+		{
+			name:     "undo everything",
+			steps:    []string{"push a", "push b", "undo", "undo", "undo"},
+			want:     []string{},
+			wantRedo: 2,
+		},
+		{
+			name:     "a new change clears redo",
+			steps:    []string{"push a", "push b", "undo", "push c", "redo"},
+			want:     []string{"c", "a"},
+			wantUndo: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &Journal{}
+			got := replay(t, j, tt.steps)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stack = %q, want %q", got, tt.want)
+			}
+			if len(j.Undo) != tt.wantUndo || len(j.Redo) != tt.wantRedo {
+				t.Errorf("journal has %d to undo and %d to redo, want %d and %d", len(j.Undo), len(j.Redo), tt.wantUndo, tt.wantRedo)
+			}
+		})
+	}
+}
+
+func TestJournalDepth(t *testing.T) {
+	j := &Journal{}
+	for i := range journalDepth + 5 {
+		j.Record(JournalEntry{Op: "push", Task: fmt.Sprint(i)})
+	}
+	if len(j.Undo) != journalDepth {
+		t.Fatalf("len(Undo) = %d, want %d", len(j.Undo), journalDepth)
+	}
+	if first := j.Undo[0].Task; first != "5" {
+		t.Errorf("oldest entry = %s, want 5", first)
+	}
+}
+
+func TestJournalSurvivesReload(t *testing.T) {
+	at := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	a := Task{ID: "aaaaaa", Description: "a", StartedAt: at, Segments: []Segment{{Start: at}}}
+	b := Task{ID: "bbbbbb", Description: "b", Tags: []string{"bug"}, StartedAt: at.Add(time.Hour)}
+	j := &Journal{}
+	j.Record(JournalEntry{Op: "push", Task: "a", At: at, Before: TaskStack{Tasks: []Task{}}, After: TaskStack{Tasks: []Task{a}, Revision: 1}})
+	j.Record(JournalEntry{Op: "push", Task: "b", At: at.Add(time.Hour), Before: TaskStack{Tasks: []Task{a}, Revision: 1}, After: TaskStack{Tasks: []Task{b, a}, Revision: 2}})
+	j.Record(JournalEntry{
+		Op:      "pop",
+		Context: "work",
+		Task:    "b",
+		At:      at.Add(2 * time.Hour),
+		Before:  TaskStack{Tasks: []Task{b, a}, Revision: 2},
+		After:   TaskStack{Tasks: []Task{a}, Revision: 3},
+		Logged:  []LogEntry{{ID: "bbbbbb", Task: "b", Started: "2026-10-01T10:00:00Z", Stopped: "2026-10-01T11:00:00Z", Reason: "completed"}},
+	})
+	j.PopUndo()
+
+	path := filepath.Join(t.TempDir(), "journal.json")
+	if err := SaveJournal(j, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, j) {
+		t.Fatalf("LoadJournal() = %+v, want %+v", got, j)
+	}
+	if e := got.PopRedo(); e == nil || e.Op != "pop" || e.ContextName() != "work" {
+		t.Errorf("PopRedo() after reload = %+v, want the pop in work", e)
+	}
+	if e := got.PopUndo(); e == nil || e.Op != "pop" {
+		t.Errorf("PopUndo() after reload = %+v, want the pop", e)
+	}
+}
+
+func TestLoadJournalMissing(t *testing.T) {
+	got, err := LoadJournal(filepath.Join(t.TempDir(), "journal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Undo) != 0 || len(got.Redo) != 0 {
+		t.Errorf("LoadJournal() = %+v, want an empty journal", got)
+	}
+}
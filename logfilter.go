@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogFilter selects log entries. Zero fields match everything.
+type LogFilter struct {
+	// Since and Until bound when entries were stopped: Since <= t < Until.
+	Since time.Time
+	Until time.Time
+	// Reasons keeps only entries with one of these reasons.
+	Reasons []string
+	// Grep keeps only entries whose task matches.
+	Grep *regexp.Regexp
+	// Tags keeps only entries with at least one of these tags.
+	Tags []string
+	// Project keeps only entries for this project.
+	Project string
+	// Context keeps only entries logged in this context.
+	Context string
+	// Effective drops undone entries before filtering; see EffectiveLog.
+	Effective bool
+	// Limit keeps only the most recent Limit matching entries.
+	Limit int
+}
+
+func (f LogFilter) Apply(entries []LogEntry) []LogEntry {
+	if f.Effective {
+		entries = EffectiveLog(entries)
+	}
+	out := []LogEntry{}
+	for _, e := range entries {
+		if f.Context != "" && e.ContextName() != f.Context {
+			continue
+		}
+		if len(f.Reasons) > 0 && !slices.Contains(f.Reasons, e.Reason) {
+			continue
+		}
+		if f.Grep != nil && !f.Grep.MatchString(e.Task) {
+			continue
+		}
+		if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(tag string) bool { return slices.Contains(e.Tags, tag) }) {
+			continue
+		}
+		if f.Project != "" && e.Project != f.Project {
+			continue
+		}
+		if !f.Since.IsZero() || !f.Until.IsZero() {
+			stopped, err := time.Parse(time.RFC3339, e.Stopped)
+			if err != nil {
+				continue
+			}
+			if !f.Since.IsZero() && stopped.Before(f.Since) {
+				continue
+			}
+			if !f.Until.IsZero() && !stopped.Before(f.Until) {
+				continue
+			}
+		}
+		out = append(out, e)
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out
+}
+
+// IsZero reports whether the filter matches every entry.
+func (f LogFilter) IsZero() bool {
+	return len(f.Query()) == 0
+}
+
+// Query encodes the filter as /log query parameters.
+func (f LogFilter) Query() url.Values {
+	q := url.Values{}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		q.Set("until", f.Until.Format(time.RFC3339))
+	}
+	if len(f.Reasons) > 0 {
+		q.Set("reason", strings.Join(f.Reasons, ","))
+	}
+	if f.Grep != nil {
+		q.Set("grep", f.Grep.String())
+	}
+	if len(f.Tags) > 0 {
+		q.Set("tag", strings.Join(f.Tags, ","))
+	}
+	if f.Project != "" {
+		q.Set("project", f.Project)
+	}
+	if f.Context != "" {
+		q.Set("context", f.Context)
+	}
+	if f.Effective {
+		q.Set("effective", "1")
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return q
+}
+
+// ParseLogFilter decodes /log query parameters.
+func ParseLogFilter(q url.Values) (LogFilter, error) {
+	var f LogFilter
+	var err error
+	if s := q.Get("since"); s != "" {
+		if f.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return f, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if f.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return f, fmt.Errorf("invalid until: %v", err)
+		}
+	}
+	if s := q.Get("reason"); s != "" {
+		f.Reasons = strings.Split(s, ",")
+	}
+	if s := q.Get("grep"); s != "" {
+		if f.Grep, err = regexp.Compile(s); err != nil {
+			return f, fmt.Errorf("invalid grep pattern: %v", err)
+		}
+	}
+	if s := q.Get("tag"); s != "" {
+		f.Tags = addTags(nil, strings.Split(s, ",")...)
+	}
+	f.Project = strings.TrimPrefix(q.Get("project"), "@")
+	f.Context = q.Get("context")
+	f.Effective = q.Get("effective") == "1"
+	if s := q.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 0 {
+			return f, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	return f, nil
+}
+
+// parseTimeArg parses a --since or --until value: an RFC3339 time, a local
+// date ("2006-01-02") or date and time ("2006-01-02 15:04"), or a duration
+// before now such as "90m", "3d" or "2w". A bare date given as an upper bound
+// covers that whole day.
+func parseTimeArg(s string, now time.Time, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if d, err := parseAgo(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("can't parse time %q", s)
+}
+
+// parseAgo parses a Go duration, also accepting whole days ("3d") and weeks
+// ("2w").
+func parseAgo(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			if v, err := strconv.Atoi(n); err == nil {
+				return time.Duration(v) * unit, nil
+			}
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// startOfDay returns local midnight on t's day.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns local midnight on the Monday of t's ISO week.
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
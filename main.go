@@ -2,14 +2,23 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
-
-	"golang.org/x/term"
+	"time"
 )
 
-const Version = "0.2.2"
+// Version is compared with the running daemon's, which is restarted when they
+// differ, so bump it whenever the client and daemon stop understanding each
+// other's requests.
+const Version = "0.3.0"
+
+// selectedContext is the context chosen with --context or MEMO_CONTEXT. Empty
+// means the daemon's current context.
+var selectedContext string
 
 func connectClient() *memoClient {
 	ensureDaemon()
@@ -32,94 +41,127 @@ func connectClient() *memoClient {
 		ensureDaemon()
 		c = newClient()
 	}
+	c.context = selectedContext
 	return c
 }
 
+// taskID matches a task ID as generated by TaskStack.newID.
+var taskID = regexp.MustCompile(`^[0-9a-f]{6}$`)
+
 func main() {
-	args := os.Args[1:]
+	configErr := loadConfig()
+	selectedContext = os.Getenv("MEMO_CONTEXT")
+	jsonOutput = config.Load().Output == "json"
+	if format := os.Getenv("MEMO_FORMAT"); format != "" {
+		jsonOutput = format == "json"
+	}
+	fs, _ := (&command{}).setup()
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			printUsage()
+			return
+		}
+		failUsage(fmt.Sprintf("memo: %v\nRun \"memo help\" for usage.", err))
+	}
+	args := fs.Args()
+	// A broken config file can still be fixed with memo config set.
+	if configErr != nil {
+		if len(args) == 0 || args[0] != "config" {
+			fail(cliError{Exit: exitError, Code: "error", Message: configErr.Error()})
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v\n", configErr)
+	}
 
 	if len(args) == 0 {
 		c := connectClient()
 		c.Current()
 		return
 	}
+	cmd := findCommand(commands, args[0])
+	if cmd == nil {
+		failUsage(fmt.Sprintf("memo: unknown command %q\nRun \"memo help\" for usage.", args[0]))
+	}
+	runCommand(cmd, args[1:])
+}
 
-	switch args[0] {
-	case "stack":
-		c := connectClient()
-		if term.IsTerminal(int(os.Stdout.Fd())) {
-			runTUI(c)
-		} else {
-			c.Stack()
+// logFlags registers the filtering flags taken by log, history and report.
+// The returned function builds the filter once the flags are parsed.
+func logFlags(fs *flag.FlagSet) func() LogFilter {
+	name := fs.Name()
+	since := fs.String("since", "", "only entries from this `time` on (date, time or duration ago like 3d)")
+	until := fs.String("until", "", "only entries before this `time` (a date includes that whole day)")
+	today := fs.Bool("today", false, "only entries from today")
+	week := fs.Bool("week", false, "only entries from this week, starting Monday")
+	grep := fs.String("grep", "", "only tasks matching this regular `expression` (case-insensitive)")
+	tag := fs.String("tag", "", "only tasks with one of these comma-separated `tags`")
+	project := fs.String("project", "", "only tasks in this `project`")
+	var reason *string
+	var limit *int
+	if name == "log" || name == "history" {
+		limit = fs.Int("limit", 0, "only the most recent `n` entries")
+	}
+	if name == "log" {
+		reason = fs.String("reason", "", "only entries with these comma-separated `reasons` (pushed, popped, ...)")
+	}
+
+	return func() LogFilter {
+		now := time.Now()
+		filter := LogFilter{Context: selectedContext}
+		var err error
+		if *since != "" {
+			if filter.Since, err = parseTimeArg(*since, now, false); err != nil {
+				failUsage(fmt.Sprintf("memo %s: --since: %v", name, err))
+			}
 		}
-		return
-	case "push":
-		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "Usage: memo push <description>")
-			os.Exit(1)
+		if *until != "" {
+			if filter.Until, err = parseTimeArg(*until, now, true); err != nil {
+				failUsage(fmt.Sprintf("memo %s: --until: %v", name, err))
+			}
+		}
+		if *today {
+			filter.Since = startOfDay(now)
+		}
+		if *week {
+			filter.Since = startOfWeek(now)
+		}
+		if reason != nil && *reason != "" {
+			filter.Reasons = strings.Split(*reason, ",")
+		}
+		if *tag != "" {
+			filter.Tags = addTags(nil, strings.Split(*tag, ",")...)
 		}
-		description := strings.Join(args[1:], " ")
-		runClient("push", description)
-	case "pop":
-		runClient("pop")
-	case "drop":
-		runClient("drop")
-	case "switch":
-		runClient("switch")
-	case "log":
-		runClient("log")
-	case "history":
-		runClient("history")
-	case "queue":
-		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "Usage: memo queue <description>")
-			os.Exit(1)
+		filter.Project = strings.TrimPrefix(*project, "@")
+		if limit != nil {
+			filter.Limit = *limit
 		}
-		description := strings.Join(args[1:], " ")
-		runClient("queue", description)
-	case "__daemon":
-		runDaemon()
-	case "--help", "-h", "help":
-		printUsage()
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
-		printUsage()
-		os.Exit(1)
+		if *grep != "" {
+			if filter.Grep, err = regexp.Compile("(?i)" + *grep); err != nil {
+				failUsage(fmt.Sprintf("memo %s: --grep: %v", name, err))
+			}
+		}
+		return filter
 	}
 }
 
-func runClient(command string, args ...string) {
-	c := connectClient()
-	switch command {
-	case "push":
-		c.Push(args[0])
-	case "pop":
-		c.Pop()
-	case "drop":
-		c.Drop()
-	case "switch":
-		c.Switch()
-	case "queue":
-		c.Queue(args[0])
-	case "log":
-		c.Log()
-	case "history":
-		c.History()
+// editTarget splits the task to edit off the front of memo edit's
+// arguments: a position from the top (1 is the current task) or a task ID.
+// A lone argument is always the new description.
+func editTarget(args []string) (id string, position int, rest []string) {
+	if len(args) < 2 {
+		return "", 0, args
+	}
+	if n, err := strconv.Atoi(args[0]); err == nil && n > 0 && len(args[0]) < 6 {
+		return "", n, args[1:]
+	}
+	if taskID.MatchString(args[0]) {
+		return args[0], 0, args[1:]
 	}
+	return "", 0, args
 }
 
-func printUsage() {
-	fmt.Println(`memo - task stack manager
-
-Usage:
-  memo                    Show current task
-  memo stack              Interactive task reorder (or show stack if non-interactive)
-  memo push <description> Push a new task onto the stack
-  memo pop                Pop the current task off the stack
-  memo drop               Drop the current task without completing it
-  memo switch             Swap the top two tasks
-  memo queue <description> Add a task to the bottom of the stack
-  memo log                Show all task activity log
-  memo history            Show completed tasks with durations
-  memo --help             Show this help message`)
+func optionalArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Exit codes, so scripts can tell failures apart.
+const (
+	exitError       = 1
+	exitBadRequest  = 2
+	exitStackEmpty  = 3
+	exitUnreachable = 4
+)
+
+// jsonOutput is set by --json or MEMO_FORMAT=json.
+var jsonOutput bool
+
+// cliError is a failure reported to the user, with the exit code and the
+// stable code printed in --json mode.
+type cliError struct {
+	Exit    int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiError is an error response from the daemon.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func isStatus(err error, status int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
+
+// fail reports e and exits. In --json mode the error is printed to stderr as
+// {"error": {"code": ..., "message": ...}}.
+func fail(e cliError) {
+	switch {
+	case jsonOutput:
+		data, _ := json.Marshal(struct {
+			Error cliError `json:"error"`
+		}{e})
+		fmt.Fprintln(os.Stderr, string(data))
+	case e.Exit == exitStackEmpty:
+		fmt.Println(e.Message)
+	default:
+		fmt.Fprintf(os.Stderr, "error: %s\n", e.Message)
+	}
+	os.Exit(e.Exit)
+}
+
+// failErr reports err, classifying daemon responses and connection failures.
+func failErr(err error) {
+	var apiErr *apiError
+	var netErr *net.OpError
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.Status == http.StatusBadRequest || apiErr.Status == http.StatusNotFound):
+		code := "bad_request"
+		if apiErr.Status == http.StatusNotFound {
+			code = "not_found"
+		}
+		fail(cliError{Exit: exitBadRequest, Code: code, Message: apiErr.Message})
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict:
+		fail(cliError{Exit: exitError, Code: "conflict", Message: apiErr.Message})
+	case errors.As(err, &netErr):
+		fail(cliError{Exit: exitUnreachable, Code: "daemon_unreachable", Message: err.Error()})
+	default:
+		fail(cliError{Exit: exitError, Code: "error", Message: err.Error()})
+	}
+}
+
+// failUsage reports a malformed command line. Outside --json mode the message
+// is printed as is, without an "error:" prefix.
+func failUsage(msg string) {
+	if !jsonOutput {
+		fmt.Fprintln(os.Stderr, msg)
+		os.Exit(exitBadRequest)
+	}
+	fail(cliError{Exit: exitBadRequest, Code: "bad_request", Message: msg})
+}
+
+func failEmpty(msg string) {
+	fail(cliError{Exit: exitStackEmpty, Code: "stack_empty", Message: msg})
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// taskOutput is a task as printed in --json mode, with its computed times.
+type taskOutput struct {
+	Task
+	Running       bool  `json:"running"`
+	ActiveSeconds int64 `json:"active_seconds"`
+	AgeSeconds    int64 `json:"age_seconds"`
+}
+
+func taskJSON(t *Task, now time.Time) *taskOutput {
+	if t == nil {
+		return nil
+	}
+	return &taskOutput{
+		Task:          *t,
+		Running:       t.Running(),
+		ActiveSeconds: seconds(t.Active(now)),
+		AgeSeconds:    seconds(now.Sub(t.StartedAt)),
+	}
+}
+
+func tasksJSON(tasks []Task, now time.Time) []*taskOutput {
+	out := make([]*taskOutput, len(tasks))
+	for i := range tasks {
+		out[i] = taskJSON(&tasks[i], now)
+	}
+	return out
+}
+
+// logOutput is a log entry as printed in --json mode, with its computed
+// durations.
+type logOutput struct {
+	LogEntry
+	SessionSeconds int64 `json:"session_seconds"`
+	ActiveSeconds  int64 `json:"active_seconds"`
+	AgeSeconds     int64 `json:"age_seconds"`
+}
+
+func logJSON(entries []LogEntry) []logOutput {
+	out := make([]logOutput, len(entries))
+	for i, e := range entries {
+		out[i] = logOutput{
+			LogEntry:       e,
+			SessionSeconds: seconds(e.Session()),
+			ActiveSeconds:  seconds(e.ActiveTime()),
+			AgeSeconds:     seconds(e.Age()),
+		}
+	}
+	return out
+}
+
+// reportOutput is a report as printed in --json mode.
+type reportOutput struct {
+	By           string            `json:"by"`
+	Since        *time.Time        `json:"since,omitempty"`
+	Until        *time.Time        `json:"until,omitempty"`
+	Rows         []reportRowOutput `json:"rows"`
+	TotalSeconds int64             `json:"total_seconds"`
+	Pomodoros    int               `json:"pomodoros"`
+	BreakSeconds int64             `json:"break_seconds"`
+}
+
+type reportRowOutput struct {
+	Key       string `json:"key"`
+	Seconds   int64  `json:"seconds"`
+	Percent   int    `json:"percent"`
+	Sessions  int    `json:"sessions"`
+	Pomodoros int    `json:"pomodoros"`
+}
+
+func reportJSON(r *Report) reportOutput {
+	out := reportOutput{By: r.By, Rows: []reportRowOutput{}, TotalSeconds: seconds(r.Total), Pomodoros: r.Pomodoros, BreakSeconds: seconds(r.Breaks)}
+	if !r.Since.IsZero() {
+		out.Since = &r.Since
+	}
+	if !r.Until.IsZero() {
+		out.Until = &r.Until
+	}
+	for _, row := range r.Rows {
+		out.Rows = append(out.Rows, reportRowOutput{row.Key, seconds(row.Time), r.percent(row.Time), row.Sessions, row.Pomodoros})
+	}
+	return out
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d.Round(time.Second) / time.Second)
+}
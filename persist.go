@@ -9,8 +9,8 @@ import (
 	"time"
 )
 
-func SaveState(stack *TaskStack, path string) error {
-	data, err := json.MarshalIndent(stack, "", "  ")
+func SaveState(state *State, path string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -21,29 +21,111 @@ func SaveState(stack *TaskStack, path string) error {
 	return os.Rename(tmp, path)
 }
 
-func LoadState(path string) (*TaskStack, error) {
+func LoadState(path string) (*State, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &TaskStack{}, nil
+			return newState(), nil
 		}
 		return nil, err
 	}
-	var stack TaskStack
-	if err := json.Unmarshal(data, &stack); err != nil {
+	var file struct {
+		State
+		// Tasks holds the single stack written before contexts existed.
+		Tasks []Task `json:"tasks"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
 		return nil, err
 	}
-	if stack.Tasks == nil {
-		stack.Tasks = []Task{}
+	state := &file.State
+	migrated := false
+	if state.Contexts == nil {
+		state.Contexts = map[string]*TaskStack{defaultContext: {Tasks: file.Tasks}}
+		migrated = true
+	}
+	if state.Current == "" {
+		state.Current = defaultContext
+		migrated = true
+	}
+	if state.Contexts[state.Current] == nil {
+		state.Contexts[state.Current] = &TaskStack{}
+		migrated = true
+	}
+	for name, stack := range state.Contexts {
+		if stack.Tasks == nil {
+			stack.Tasks = []Task{}
+		}
+		// State written before tasks had IDs or segments is migrated on
+		// first load. The top task is assumed to have been running since it
+		// started; earlier pauses weren't recorded.
+		if stack.assignIDs() {
+			migrated = true
+		}
+		if top := stack.Peek(); top != nil && len(top.Segments) == 0 && name == state.Current {
+			top.Segments = []Segment{{Start: top.StartedAt}}
+			migrated = true
+		}
+	}
+	if migrated {
+		if err := SaveState(state, path); err != nil {
+			return nil, err
+		}
 	}
-	return &stack, nil
+	return state, nil
 }
 
 type LogEntry struct {
-	Task    string `json:"task"`
-	Started string `json:"started"`
-	Stopped string `json:"stopped"`
-	Reason  string `json:"reason"`
+	ID      string   `json:"id,omitempty"`
+	Context string   `json:"context,omitempty"`
+	Task    string   `json:"task"`
+	Tags    []string `json:"tags,omitempty"`
+	Project string   `json:"project,omitempty"`
+	Started string   `json:"started"`
+	Stopped string   `json:"stopped"`
+	Reason  string   `json:"reason"`
+	// Resumed is when the work session ending at Stopped began. It is empty
+	// if the task wasn't running when it stopped.
+	Resumed string `json:"resumed,omitempty"`
+	// Active is the total time spent on the task up to Stopped, as a Go
+	// duration string. Entries written before it was tracked leave it empty.
+	Active string `json:"active,omitempty"`
+	// Previous is the task's label before an "edited" entry.
+	Previous string `json:"previous,omitempty"`
+	// Undoes is the reason of the entry cancelled by an "undone" entry.
+	Undoes string `json:"undoes,omitempty"`
+	// Notes are the task's notes, carried over when it is popped or dropped.
+	Notes []Note `json:"notes,omitempty"`
+}
+
+// Session returns the length of the work session ending at this entry.
+// Entries written before sessions were tracked fall back to the task's age.
+func (e LogEntry) Session() time.Duration {
+	if e.Active == "" {
+		return e.Age()
+	}
+	if e.Resumed == "" {
+		return 0
+	}
+	resumed, _ := time.Parse(time.RFC3339, e.Resumed)
+	stopped, _ := time.Parse(time.RFC3339, e.Stopped)
+	return stopped.Sub(resumed)
+}
+
+// ActiveTime returns the total time spent on the task up to this entry,
+// falling back to the task's age for entries written before it was tracked.
+func (e LogEntry) ActiveTime() time.Duration {
+	if e.Active == "" {
+		return e.Age()
+	}
+	d, _ := time.ParseDuration(e.Active)
+	return d
+}
+
+// Age returns the wall-clock time from the task starting to this entry.
+func (e LogEntry) Age() time.Duration {
+	started, _ := time.Parse(time.RFC3339, e.Started)
+	stopped, _ := time.Parse(time.RFC3339, e.Stopped)
+	return stopped.Sub(started)
 }
 
 func LoadLog(path string) ([]LogEntry, error) {
@@ -71,13 +153,78 @@ func LoadLog(path string) ([]LogEntry, error) {
 	return entries, nil
 }
 
-func LogTaskStop(path string, task Task, stoppedAt time.Time, reason string) error {
+// ContextName returns the context the entry was logged in. Entries written
+// before contexts existed belong to the default context.
+func (e LogEntry) ContextName() string {
+	if e.Context == "" {
+		return defaultContext
+	}
+	return e.Context
+}
+
+// EffectiveLog returns entries with undone entries removed, along with the
+// "undone" markers that cancelled them.
+func EffectiveLog(entries []LogEntry) []LogEntry {
+	var out []LogEntry
+	for _, e := range entries {
+		if e.Reason != "undone" {
+			out = append(out, e)
+			continue
+		}
+		for i := len(out) - 1; i >= 0; i-- {
+			if out[i].ID == e.ID && out[i].Reason == e.Undoes {
+				out = append(out[:i], out[i+1:]...)
+				break
+			}
+		}
+	}
+	if out == nil {
+		out = []LogEntry{}
+	}
+	return out
+}
+
+// LogTaskStop appends an entry recording that task stopped running and
+// returns it.
+func LogTaskStop(store Storage, context string, task Task, stoppedAt time.Time, reason string) (LogEntry, error) {
 	entry := LogEntry{
+		ID:      task.ID,
+		Context: context,
 		Task:    task.Description,
+		Tags:    task.Tags,
+		Project: task.Project,
 		Started: task.StartedAt.Format(time.RFC3339),
 		Stopped: stoppedAt.Format(time.RFC3339),
 		Reason:  reason,
+		Active:  task.Active(stoppedAt).Round(time.Second).String(),
+	}
+	if seg := task.openSegment(); seg != nil {
+		entry.Resumed = seg.Start.Format(time.RFC3339)
+	}
+	if reason == "popped" || reason == "dropped" {
+		entry.Notes = task.Notes
 	}
+	return entry, store.AppendLog(entry)
+}
+
+// LogTaskEdit appends an "edited" entry recording that task was renamed from
+// previous and returns it.
+func LogTaskEdit(store Storage, context string, task Task, previous string, at time.Time) (LogEntry, error) {
+	entry := LogEntry{
+		ID:       task.ID,
+		Context:  context,
+		Task:     task.Description,
+		Tags:     task.Tags,
+		Project:  task.Project,
+		Started:  task.StartedAt.Format(time.RFC3339),
+		Stopped:  at.Format(time.RFC3339),
+		Reason:   "edited",
+		Previous: previous,
+	}
+	return entry, store.AppendLog(entry)
+}
+
+func AppendLog(path string, entry LogEntry) error {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return err
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var reportGroups = []string{"task", "context", "tag", "project", "day", "week"}
+
+func validReportGroup(by string) bool {
+	return slices.Contains(reportGroups, by)
+}
+
+// ReportRow is the time spent on one group in a report, and the number of
+// full-length focus sessions.
+type ReportRow struct {
+	Key       string
+	Time      time.Duration
+	Sessions  int
+	Pomodoros int
+}
+
+// Report totals the work sessions in the log, grouped by task, context, tag,
+// project, day or ISO week. A session with several tags counts towards each,
+// so tag rows can add up to more than the total. Breaks aren't time on any
+// task and are totalled separately.
+type Report struct {
+	By        string
+	Since     time.Time
+	Until     time.Time
+	Rows      []ReportRow
+	Total     time.Duration
+	Pomodoros int
+	Breaks    time.Duration
+}
+
+// session is a stretch of work on a task taken from a log entry.
+type session struct {
+	entry      LogEntry
+	start, end time.Time
+}
+
+// sessions returns the work sessions recorded in entries, clipped to
+// [since, until). Either bound may be zero.
+func sessions(entries []LogEntry, since, until time.Time) []session {
+	var out []session
+	for _, e := range entries {
+		switch e.Reason {
+		case "undone", "edited", "focus", "break":
+			continue
+		}
+		from := e.Resumed
+		if e.Active == "" {
+			from = e.Started
+		}
+		start, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, e.Stopped)
+		if err != nil {
+			continue
+		}
+		if !since.IsZero() && start.Before(since) {
+			start = since
+		}
+		if !until.IsZero() && end.After(until) {
+			end = until
+		}
+		if !end.After(start) {
+			continue
+		}
+		out = append(out, session{e, start, end})
+	}
+	return out
+}
+
+// splitDays cuts a session at each local midnight it spans.
+func splitDays(s session) []session {
+	var out []session
+	s.start, s.end = s.start.Local(), s.end.Local()
+	for {
+		midnight := startOfDay(s.start).AddDate(0, 0, 1)
+		if !s.end.After(midnight) {
+			return append(out, s)
+		}
+		out = append(out, session{s.entry, s.start, midnight})
+		s.start = midnight
+	}
+}
+
+func buildReport(entries []LogEntry, by string, since, until time.Time) *Report {
+	report := &Report{By: by, Since: since, Until: until, Rows: []ReportRow{}}
+	index := map[string]int{}
+	row := func(key string) *ReportRow {
+		i, ok := index[key]
+		if !ok {
+			i = len(report.Rows)
+			index[key] = i
+			report.Rows = append(report.Rows, ReportRow{Key: key})
+		}
+		return &report.Rows[i]
+	}
+	add := func(key string, d time.Duration) {
+		r := row(key)
+		r.Time += d
+		r.Sessions++
+	}
+
+	for _, s := range sessions(entries, since, until) {
+		d := s.end.Sub(s.start)
+		report.Total += d
+		if by == "day" || by == "week" {
+			for _, part := range splitDays(s) {
+				add(periodKey(by, part.start), part.end.Sub(part.start))
+			}
+			continue
+		}
+		for _, key := range groupKeys(by, s.entry) {
+			add(key, d)
+		}
+	}
+
+	// Focus sessions and breaks count where they ended.
+	for _, e := range entries {
+		if e.Reason != "focus" && e.Reason != "break" {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, e.Stopped)
+		if err != nil || (!since.IsZero() && end.Before(since)) || (!until.IsZero() && !end.Before(until)) {
+			continue
+		}
+		if e.Reason == "break" {
+			report.Breaks += e.Age()
+			continue
+		}
+		report.Pomodoros++
+		keys := groupKeys(by, e)
+		if by == "day" || by == "week" {
+			keys = []string{periodKey(by, end)}
+		}
+		for _, key := range keys {
+			row(key).Pomodoros++
+		}
+	}
+
+	if by == "day" || by == "week" {
+		slices.SortFunc(report.Rows, func(a, b ReportRow) int { return strings.Compare(a.Key, b.Key) })
+	} else {
+		slices.SortStableFunc(report.Rows, func(a, b ReportRow) int {
+			return cmp.Or(cmp.Compare(b.Time, a.Time), strings.Compare(a.Key, b.Key))
+		})
+	}
+	return report
+}
+
+// groupKeys returns the rows of a task, context, tag or project report that
+// a log entry counts towards.
+func groupKeys(by string, e LogEntry) []string {
+	switch by {
+	case "task":
+		return []string{labelled(e.Task, e.Tags, e.Project)}
+	case "context":
+		return []string{e.ContextName()}
+	case "tag":
+		if len(e.Tags) == 0 {
+			return []string{"(untagged)"}
+		}
+		keys := []string{}
+		for _, tag := range e.Tags {
+			keys = append(keys, "+"+tag)
+		}
+		return keys
+	case "project":
+		if e.Project == "" {
+			return []string{"(no project)"}
+		}
+		return []string{"@" + e.Project}
+	}
+	return nil
+}
+
+// periodKey returns the row of a day or week report that t falls in.
+func periodKey(by string, t time.Time) string {
+	local := t.Local()
+	if by == "week" {
+		year, week := local.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return local.Format("2006-01-02")
+}
+
+// percent returns d as a whole percentage of the report's total.
+func (r *Report) percent(d time.Duration) int {
+	if r.Total == 0 {
+		return 0
+	}
+	return int((d*100 + r.Total/2) / r.Total)
+}
+
+// Text renders the report as an aligned table, or a Markdown table if
+// markdown is set. A Focus column counts pomodoros if there are any, and
+// breaks are totalled under the table.
+func (r *Report) Text(markdown bool) string {
+	header := []string{strings.ToUpper(r.By[:1]) + r.By[1:], "Time", "%"}
+	focus := r.Pomodoros > 0
+	if focus {
+		header = append(header, "Focus")
+	}
+	cells := func(key string, d time.Duration, pomodoros int) []string {
+		row := []string{key, formatDuration(d), fmt.Sprintf("%d%%", r.percent(d))}
+		if focus {
+			row = append(row, strconv.Itoa(pomodoros))
+		}
+		return row
+	}
+	rows := [][]string{}
+	for _, row := range r.Rows {
+		rows = append(rows, cells(row.Key, row.Time, row.Pomodoros))
+	}
+	total := cells("Total", r.Total, r.Pomodoros)
+	total[2] = "100%"
+
+	var b strings.Builder
+	if markdown {
+		fmt.Fprintf(&b, "| %s |\n|---%s|\n", strings.Join(header, " | "), strings.Repeat("|---:", len(header)-1))
+		for _, row := range rows {
+			row[0] = strings.ReplaceAll(row[0], "|", `\|`)
+			fmt.Fprintf(&b, "| %s |\n", strings.Join(row, " | "))
+		}
+		for i := range total {
+			total[i] = "**" + total[i] + "**"
+		}
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(total, " | "))
+		if r.Breaks > 0 {
+			fmt.Fprintf(&b, "\nBreaks: %s\n", formatDuration(r.Breaks))
+		}
+		return b.String()
+	}
+
+	width := make([]int, len(header))
+	for _, row := range append(append([][]string{header}, rows...), total) {
+		for i, cell := range row {
+			width[i] = max(width[i], len([]rune(cell)))
+		}
+	}
+	line := func(cells []string) {
+		b.WriteString(cells[0] + strings.Repeat(" ", width[0]-len([]rune(cells[0]))))
+		for i, cell := range cells[1:] {
+			fmt.Fprintf(&b, "  %*s", width[i+1], cell)
+		}
+		b.WriteString("\n")
+	}
+	line(header)
+	for _, row := range rows {
+		line(row)
+	}
+	rule := 2 * (len(width) - 1)
+	for _, w := range width {
+		rule += w
+	}
+	b.WriteString(strings.Repeat("-", rule) + "\n")
+	line(total)
+	if r.Breaks > 0 {
+		fmt.Fprintf(&b, "Breaks: %s\n", formatDuration(r.Breaks))
+	}
+	return b.String()
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// The pure-Go driver, so memo still builds without cgo.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriver is the database/sql driver the SQLite backend uses.
+const sqliteDriver = "sqlite"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS contexts (
+	name     TEXT PRIMARY KEY,
+	revision INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tasks (
+	context     TEXT NOT NULL,
+	position    INTEGER NOT NULL,
+	id          TEXT NOT NULL,
+	description TEXT NOT NULL,
+	tags        TEXT NOT NULL,
+	project     TEXT NOT NULL,
+	started_at  TEXT NOT NULL,
+	notes       TEXT NOT NULL,
+	PRIMARY KEY (context, position)
+);
+CREATE TABLE IF NOT EXISTS segments (
+	context  TEXT NOT NULL,
+	task_id  TEXT NOT NULL,
+	started  TEXT NOT NULL,
+	ended    TEXT
+);
+CREATE INDEX IF NOT EXISTS segments_by_task ON segments (context, task_id);
+CREATE TABLE IF NOT EXISTS events (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	stopped_at INTEGER NOT NULL,
+	task_id    TEXT NOT NULL,
+	context    TEXT NOT NULL,
+	task       TEXT NOT NULL,
+	tags       TEXT NOT NULL,
+	project    TEXT NOT NULL,
+	started    TEXT NOT NULL,
+	stopped    TEXT NOT NULL,
+	reason     TEXT NOT NULL,
+	resumed    TEXT NOT NULL,
+	active     TEXT NOT NULL,
+	previous   TEXT NOT NULL,
+	undoes     TEXT NOT NULL,
+	notes      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_by_time ON events (stopped_at);
+`
+
+// sqliteStorage keeps everything in one SQLite database: the stacks in the
+// contexts, tasks and segments tables, the log in the events table, and the
+// current context, idle and focus state and the undo journal in meta.
+type sqliteStorage struct {
+	db *sql.DB
+	// saved holds each context's stack as last loaded or saved, in JSON, so
+	// SaveState only rewrites the ones that changed.
+	saved map[string][]byte
+}
+
+func openSQLiteStorage(path string) (*sqliteStorage, error) {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	// The daemon serialises writes anyway; one connection avoids
+	// SQLITE_BUSY between them.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables: %v", err)
+	}
+	return &sqliteStorage{db: db}, nil
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+// isEmpty reports whether there's no data in the database yet.
+func (s *sqliteStorage) isEmpty() bool {
+	var n int
+	s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM contexts) + (SELECT COUNT(*) FROM events)`).Scan(&n)
+	return n == 0
+}
+
+func (s *sqliteStorage) LoadState() (*State, error) {
+	state := &State{Contexts: map[string]*TaskStack{}}
+	rows, err := s.db.Query(`SELECT name, revision FROM contexts`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		stack := &TaskStack{Tasks: []Task{}}
+		var name string
+		if err := rows.Scan(&name, &stack.Revision); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		state.Contexts[name] = stack
+	}
+	rows.Close()
+	if len(state.Contexts) == 0 {
+		return newState(), nil
+	}
+
+	rows, err = s.db.Query(`SELECT context, id, description, tags, project, started_at, notes FROM tasks ORDER BY context, position`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var ctx, tags, startedAt, notes string
+		var t Task
+		if err := rows.Scan(&ctx, &t.ID, &t.Description, &tags, &t.Project, &startedAt, &notes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.StartedAt, err = time.Parse(time.RFC3339Nano, startedAt)
+		if err == nil {
+			err = unmarshalColumns(tags, &t.Tags, notes, &t.Notes)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("task %s: %v", t.ID, err)
+		}
+		if stack := state.Contexts[ctx]; stack != nil {
+			stack.Tasks = append(stack.Tasks, t)
+		}
+	}
+	rows.Close()
+
+	// Segments are saved in order; their RFC 3339 times don't sort as text.
+	rows, err = s.db.Query(`SELECT context, task_id, started, ended FROM segments ORDER BY context, task_id, rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ctx, id, start string
+		var end sql.NullString
+		if err := rows.Scan(&ctx, &id, &start, &end); err != nil {
+			return nil, err
+		}
+		var seg Segment
+		if seg.Start, err = time.Parse(time.RFC3339Nano, start); err != nil {
+			return nil, err
+		}
+		if end.Valid {
+			t, err := time.Parse(time.RFC3339Nano, end.String)
+			if err != nil {
+				return nil, err
+			}
+			seg.End = &t
+		}
+		if stack := state.Contexts[ctx]; stack != nil {
+			if _, task := stack.Find(id); task != nil {
+				task.Segments = append(task.Segments, seg)
+			}
+		}
+	}
+
+	if err := s.loadMeta("current", &state.Current); err != nil {
+		return nil, err
+	}
+	if err := s.loadMeta("idle", &state.Idle); err != nil {
+		return nil, err
+	}
+	if err := s.loadMeta("focus", &state.Focus); err != nil {
+		return nil, err
+	}
+	if err := s.loadMeta("removed", &state.Removed); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.saved = map[string][]byte{}
+	for name, stack := range state.Contexts {
+		s.saved[name], _ = json.Marshal(stack)
+	}
+	if state.Contexts[state.Current] == nil {
+		state.Current = defaultContext
+		state.Contexts[state.Current] = &TaskStack{Tasks: []Task{}}
+	}
+	return state, nil
+}
+
+// SaveState writes the stacks that changed since they were last loaded or
+// saved, and drops removed contexts, in one transaction.
+func (s *sqliteStorage) SaveState(state *State) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	saved := map[string][]byte{}
+	for name, stack := range state.Contexts {
+		data, err := json.Marshal(stack)
+		if err != nil {
+			return err
+		}
+		saved[name] = data
+		if bytes.Equal(data, s.saved[name]) {
+			continue
+		}
+		if err := saveStack(tx, name, stack); err != nil {
+			return err
+		}
+	}
+	for name := range s.saved {
+		if _, ok := state.Contexts[name]; ok {
+			continue
+		}
+		for _, table := range []string{"tasks", "segments"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE context = ?`, name); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM contexts WHERE name = ?`, name); err != nil {
+			return err
+		}
+	}
+	for key, value := range map[string]any{"current": state.Current, "idle": state.Idle, "focus": state.Focus, "removed": state.Removed} {
+		if err := saveMeta(tx, key, value); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.saved = saved
+	return nil
+}
+
+// saveStack replaces the rows of one context's stack.
+func saveStack(tx *sql.Tx, name string, stack *TaskStack) error {
+	for _, table := range []string{"tasks", "segments"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE context = ?`, name); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO contexts (name, revision) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET revision = excluded.revision`, name, stack.Revision); err != nil {
+		return err
+	}
+	for i, t := range stack.Tasks {
+		tags, _ := json.Marshal(t.Tags)
+		notes, _ := json.Marshal(t.Notes)
+		if _, err := tx.Exec(`INSERT INTO tasks (context, position, id, description, tags, project, started_at, notes) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			name, i, t.ID, t.Description, string(tags), t.Project, t.StartedAt.Format(time.RFC3339Nano), string(notes)); err != nil {
+			return err
+		}
+		for _, seg := range t.Segments {
+			var end any
+			if seg.End != nil {
+				end = seg.End.Format(time.RFC3339Nano)
+			}
+			if _, err := tx.Exec(`INSERT INTO segments (context, task_id, started, ended) VALUES (?, ?, ?, ?)`,
+				name, t.ID, seg.Start.Format(time.RFC3339Nano), end); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStorage) LoadJournal() (*Journal, error) {
+	j := &Journal{}
+	return j, s.loadMeta("journal", j)
+}
+
+func (s *sqliteStorage) SaveJournal(j *Journal) error {
+	return saveMeta(s.db, "journal", j)
+}
+
+func (s *sqliteStorage) AppendLog(entries ...LogEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		stopped, err := time.Parse(time.RFC3339, e.Stopped)
+		if err != nil {
+			return fmt.Errorf("invalid stop time %q", e.Stopped)
+		}
+		tags, _ := json.Marshal(e.Tags)
+		notes, _ := json.Marshal(e.Notes)
+		if _, err := tx.Exec(`INSERT INTO events (stopped_at, task_id, context, task, tags, project, started, stopped, reason, resumed, active, previous, undoes, notes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			stopped.Unix(), e.ID, e.Context, e.Task, string(tags), e.Project, e.Started, e.Stopped, e.Reason, e.Resumed, e.Active, e.Previous, e.Undoes, string(notes)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) LoadLog(since time.Time) ([]LogEntry, error) {
+	var from int64
+	if !since.IsZero() {
+		from = since.Unix()
+	}
+	rows, err := s.db.Query(`SELECT task_id, context, task, tags, project, started, stopped, reason, resumed, active, previous, undoes, notes FROM events WHERE stopped_at >= ? ORDER BY seq`, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []LogEntry{}
+	for rows.Next() {
+		var e LogEntry
+		var tags, notes string
+		if err := rows.Scan(&e.ID, &e.Context, &e.Task, &tags, &e.Project, &e.Started, &e.Stopped, &e.Reason, &e.Resumed, &e.Active, &e.Previous, &e.Undoes, &notes); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumns(tags, &e.Tags, notes, &e.Notes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// loadMeta decodes the JSON stored under key into v, leaving v alone if
+// there's nothing stored.
+func (s *sqliteStorage) loadMeta(key string, v any) error {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+func saveMeta(db interface {
+	Exec(string, ...any) (sql.Result, error)
+}, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO meta (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, string(data))
+	return err
+}
+
+// unmarshalColumns decodes the JSON tags and notes columns, which hold null
+// for a task without any.
+func unmarshalColumns(tags string, tagsOut *[]string, notes string, notesOut *[]Note) error {
+	if err := json.Unmarshal([]byte(tags), tagsOut); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(notes), notesOut)
+}
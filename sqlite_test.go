@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrateToSQLite(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	ptr := func(ts time.Time) *time.Time { return &ts }
+
+	// The first task's segments sort the other way round as text.
+	fix := Task{
+		ID:          "a1b2c3",
+		Description: "fix auth bug",
+		Tags:        []string{"bug", "security"},
+		Project:     "memo",
+		StartedAt:   at("2026-10-01T09:00:00Z"),
+		Segments: []Segment{
+			{Start: at("2026-10-01T09:00:00Z"), End: ptr(at("2026-10-01T09:30:00.5Z"))},
+			{Start: at("2026-10-01T10:00:00Z"), End: ptr(at("2026-10-01T10:00:00.25Z"))},
+			{Start: at("2026-10-01T10:00:00.5Z")},
+		},
+		Notes: []Note{{At: at("2026-10-01T09:29:00Z"), Text: "token refresh races"}},
+	}
+	docs := Task{
+		ID:          "d4e5f6",
+		Description: "write docs",
+		StartedAt:   at("2026-10-01T08:00:00Z"),
+		Segments:    []Segment{{Start: at("2026-10-01T08:00:00Z"), End: ptr(at("2026-10-01T09:00:00Z"))}},
+	}
+	home := Task{ID: "0a0b0c", Description: "call the plumber", StartedAt: at("2026-09-30T18:00:00Z")}
+
+	state := &State{
+		Current: defaultContext,
+		Contexts: map[string]*TaskStack{
+			defaultContext: {Tasks: []Task{fix, docs}, Revision: 7},
+			"home":         {Tasks: []Task{home}, Revision: 2},
+			"empty":        {Tasks: []Task{}, Revision: 1},
+		},
+		Removed: map[string]int64{"errands": 4},
+	}
+	journal := &Journal{
+		Undo: []JournalEntry{{
+			Op:     "push",
+			Task:   fix.Description,
+			At:     fix.StartedAt,
+			Before: TaskStack{Tasks: []Task{docs}, Revision: 6},
+			After:  TaskStack{Tasks: []Task{fix, docs}, Revision: 7},
+		}},
+		Redo: []JournalEntry{{
+			Op:      "drop",
+			Context: "home",
+			Task:    "buy milk",
+			At:      at("2026-09-30T19:00:00Z"),
+			Before:  TaskStack{Tasks: []Task{home}, Revision: 2},
+			After:   TaskStack{Tasks: []Task{}, Revision: 3},
+			Logged:  []LogEntry{{ID: "0d0e0f", Context: "home", Task: "buy milk", Started: "2026-09-30T17:00:00Z", Stopped: "2026-09-30T19:00:00Z", Reason: "dropped"}},
+		}},
+	}
+	entries := []LogEntry{
+		{ID: "111111", Task: "review PR", Tags: []string{"review"}, Project: "memo", Started: "2026-09-29T09:00:00Z", Stopped: "2026-09-29T10:00:00Z", Reason: "completed", Resumed: "2026-09-29T09:15:00Z", Active: "45m0s"},
+		{ID: "222222", Context: "home", Task: "water plants", Started: "2026-09-29T18:00:00Z", Stopped: "2026-09-29T18:10:00Z", Reason: "dropped", Notes: []Note{{At: at("2026-09-29T18:05:00Z"), Text: "only the ferns"}}},
+		{ID: "111111", Task: "review PR", Started: "2026-09-29T09:00:00Z", Stopped: "2026-09-29T10:05:00Z", Reason: "undone", Undoes: "completed"},
+	}
+
+	dir := t.TempDir()
+	from := jsonStorage{dir}
+	if err := from.SaveState(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.SaveJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.AppendLog(entries...); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "memo.db")
+	to, err := openSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := migrateStorage(from, to)
+	if err != nil {
+		t.Fatalf("migrateStorage() error = %v", err)
+	}
+	if n != len(entries) {
+		t.Errorf("migrateStorage() = %d, want %d", n, len(entries))
+	}
+	to.Close()
+
+	db, err := openSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got, err := db.LoadState(); err != nil {
+		t.Errorf("LoadState() error = %v", err)
+	} else if !reflect.DeepEqual(got, state) {
+		t.Errorf("LoadState() = %+v, want %+v", got, state)
+	}
+	if got, err := db.LoadJournal(); err != nil {
+		t.Errorf("LoadJournal() error = %v", err)
+	} else if !reflect.DeepEqual(got, journal) {
+		t.Errorf("LoadJournal() = %+v, want %+v", got, journal)
+	}
+	if got, err := db.LoadLog(time.Time{}); err != nil {
+		t.Errorf("LoadLog() error = %v", err)
+	} else if !reflect.DeepEqual(got, entries) {
+		t.Errorf("LoadLog() = %+v, want %+v", got, entries)
+	}
+}
@@ -1,22 +1,143 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 )
 
 type Task struct {
+	ID          string    `json:"id"`
 	Description string    `json:"description"`
+	Tags        []string  `json:"tags,omitempty"`
+	Project     string    `json:"project,omitempty"`
 	StartedAt   time.Time `json:"started_at"`
+	Segments    []Segment `json:"segments,omitempty"`
+	Notes       []Note    `json:"notes,omitempty"`
+}
+
+// Note is a timestamped remark attached to a task, such as where work on it
+// left off.
+type Note struct {
+	At   time.Time `json:"at"`
+	Text string    `json:"text"`
+}
+
+// Segment is a stretch of time during which a task was on top of the stack.
+// End is nil while the segment is still open.
+type Segment struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Copy returns a copy of the task that shares no memory with it.
+func (t Task) Copy() Task {
+	t.Segments = append([]Segment(nil), t.Segments...)
+	t.Notes = append([]Note(nil), t.Notes...)
+	t.Tags = append([]string(nil), t.Tags...)
+	return t
+}
+
+// Running reports whether the task has an open segment.
+func (t *Task) Running() bool {
+	return t.openSegment() != nil
+}
+
+func (t *Task) openSegment() *Segment {
+	if len(t.Segments) == 0 {
+		return nil
+	}
+	seg := &t.Segments[len(t.Segments)-1]
+	if seg.End != nil {
+		return nil
+	}
+	return seg
+}
+
+// Resume opens a new segment at now unless one is already open.
+func (t *Task) Resume(now time.Time) {
+	if t.Running() {
+		return
+	}
+	t.Segments = append(t.Segments, Segment{Start: now})
+}
+
+// Pause closes the open segment, if any, at now.
+func (t *Task) Pause(now time.Time) {
+	if seg := t.openSegment(); seg != nil {
+		end := now
+		seg.End = &end
+	}
+}
+
+// AddNote appends a note to the task.
+func (t *Task) AddNote(now time.Time, text string) {
+	t.Notes = append(t.Notes, Note{At: now, Text: text})
+}
+
+// Active returns the time spent on the task, counting an open segment up to
+// now.
+func (t *Task) Active(now time.Time) time.Duration {
+	var total time.Duration
+	for _, seg := range t.Segments {
+		end := now
+		if seg.End != nil {
+			end = *seg.End
+		}
+		total += end.Sub(seg.Start)
+	}
+	return total
+}
+
+// AddSegment records after the fact that the task was worked on from start
+// to end, which must not overlap its other segments.
+func (t *Task) AddSegment(start, end time.Time) {
+	i, _ := slices.BinarySearchFunc(t.Segments, start, func(seg Segment, start time.Time) int {
+		return seg.Start.Compare(start)
+	})
+	t.Segments = slices.Insert(t.Segments, i, Segment{Start: start, End: &end})
 }
 
 type TaskStack struct {
 	Tasks []Task `json:"tasks"`
+	// Revision goes up by one with every change to the stack, so clients
+	// can tell whether they have the latest version.
+	Revision int64 `json:"revision"`
+}
+
+// newID returns a short random hex ID not used by any task on the stack.
+func (s *TaskStack) newID() string {
+	b := make([]byte, 3)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		id := hex.EncodeToString(b)
+		if i, _ := s.Find(id); i < 0 {
+			return id
+		}
+	}
+}
+
+// assignIDs gives an ID to every task that lacks one and reports whether any
+// were assigned.
+func (s *TaskStack) assignIDs() bool {
+	assigned := false
+	for i := range s.Tasks {
+		if s.Tasks[i].ID == "" {
+			s.Tasks[i].ID = s.newID()
+			assigned = true
+		}
+	}
+	return assigned
 }
 
 func (s *TaskStack) Push(description string) *Task {
 	t := Task{
+		ID:          s.newID(),
 		Description: description,
 		StartedAt:   time.Now().UTC(),
 	}
@@ -40,6 +161,70 @@ func (s *TaskStack) Peek() *Task {
 	return &s.Tasks[0]
 }
 
+// Find returns the position and task with the given ID, or -1 and nil if no
+// task has that ID.
+func (s *TaskStack) Find(id string) (int, *Task) {
+	for i := range s.Tasks {
+		if s.Tasks[i].ID == id {
+			return i, &s.Tasks[i]
+		}
+	}
+	return -1, nil
+}
+
+// At returns the task at a 1-based position from the top, or nil if there is
+// no such position.
+func (s *TaskStack) At(position int) *Task {
+	if position < 1 || position > len(s.Tasks) {
+		return nil
+	}
+	return &s.Tasks[position-1]
+}
+
+// Remove takes the task with the given ID out of the stack and returns it.
+func (s *TaskStack) Remove(id string) *Task {
+	i, _ := s.Find(id)
+	if i < 0 {
+		return nil
+	}
+	t := s.Tasks[i]
+	s.Tasks = append(s.Tasks[:i:i], s.Tasks[i+1:]...)
+	return &t
+}
+
+// MoveToTop brings the task with the given ID to the top of the stack,
+// keeping the order of the others.
+func (s *TaskStack) MoveToTop(id string) *Task {
+	i, _ := s.Find(id)
+	if i < 0 {
+		return nil
+	}
+	t := s.Tasks[i]
+	copy(s.Tasks[1:i+1], s.Tasks[:i])
+	s.Tasks[0] = t
+	return &s.Tasks[0]
+}
+
+// Settle makes sure only the top task is running: it opens a segment on the
+// top task and closes any left open further down.
+func (s *TaskStack) Settle(now time.Time) {
+	for i := range s.Tasks {
+		if i == 0 {
+			s.Tasks[i].Resume(now)
+		} else {
+			s.Tasks[i].Pause(now)
+		}
+	}
+}
+
+// PauseAll closes every open segment. It keeps stacks in contexts other than
+// the current one from accruing time.
+func (s *TaskStack) PauseAll(now time.Time) {
+	for i := range s.Tasks {
+		s.Tasks[i].Pause(now)
+	}
+}
+
 func (s *TaskStack) List() []Task {
 	return s.Tasks
 }
@@ -56,8 +241,26 @@ func (s *TaskStack) Switch() (started, paused *Task) {
 	return &s.Tasks[0], &s.Tasks[1]
 }
 
+// Insert adds a task at a 1-based position from the top, between Push
+// (position 1) and Queue (position Len()+1). It returns nil if there is no
+// such position.
+func (s *TaskStack) Insert(position int, description string) *Task {
+	if position < 1 || position > len(s.Tasks)+1 {
+		return nil
+	}
+	t := Task{
+		ID:          s.newID(),
+		Description: description,
+		StartedAt:   time.Now().UTC(),
+	}
+	i := position - 1
+	s.Tasks = slices.Insert(s.Tasks, i, t)
+	return &s.Tasks[i]
+}
+
 func (s *TaskStack) Queue(description string) *Task {
 	t := Task{
+		ID:          s.newID(),
 		Description: description,
 		StartedAt:   time.Now().UTC(),
 	}
@@ -87,6 +290,29 @@ func (s *TaskStack) Reorder(order []int) error {
 	return nil
 }
 
+// ReorderIDs reorders the stack to match ids, which must name every task
+// exactly once.
+func (s *TaskStack) ReorderIDs(ids []string) error {
+	order := make([]int, len(ids))
+	for i, id := range ids {
+		idx, _ := s.Find(id)
+		if idx < 0 {
+			return fmt.Errorf("unknown task %s", id)
+		}
+		order[i] = idx
+	}
+	return s.Reorder(order)
+}
+
+// Clone returns a deep copy of the stack.
+func (s *TaskStack) Clone() *TaskStack {
+	c := &TaskStack{Tasks: make([]Task, len(s.Tasks)), Revision: s.Revision}
+	for i, t := range s.Tasks {
+		c.Tasks[i] = t.Copy()
+	}
+	return c
+}
+
 func (s *TaskStack) MarshalJSON() ([]byte, error) {
 	type Alias TaskStack
 	return json.Marshal(&struct{ *Alias }{Alias: (*Alias)(s)})
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Status is what a status line needs to know, served by GET /status so it
+// can be polled every second without fetching the whole stack.
+type Status struct {
+	Context       string        `json:"context"`
+	ID            string        `json:"id,omitempty"`
+	Description   string        `json:"description,omitempty"`
+	Label         string        `json:"label,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	Project       string        `json:"project,omitempty"`
+	Running       bool          `json:"running"`
+	ActiveSeconds int64         `json:"active_seconds"`
+	Depth         int           `json:"depth"`
+	Next          string        `json:"next,omitempty"`
+	Away          bool          `json:"away"`
+	Focus         *FocusSession `json:"focus,omitempty"`
+	At            time.Time     `json:"at"`
+}
+
+func newStatus(ctx string, stack *TaskStack, state *State, now time.Time) *Status {
+	s := &Status{Context: ctx, Depth: stack.Len(), Focus: state.Focus, At: now}
+	s.Away = state.Idle != nil && state.Idle.Returned == nil
+	tasks := stack.List()
+	if len(tasks) > 0 {
+		top := tasks[0]
+		s.ID, s.Description, s.Label = top.ID, top.Description, top.Label()
+		s.Tags, s.Project = top.Tags, top.Project
+		s.Running = top.Running()
+		s.ActiveSeconds = int64(top.Active(now).Seconds())
+	}
+	if len(tasks) > 1 {
+		s.Next = tasks[1].Label()
+	}
+	return s
+}
+
+// Elapsed is the time spent on the top task, formatted.
+func (s *Status) Elapsed() string {
+	if s.ID == "" {
+		return ""
+	}
+	return formatDuration(time.Duration(s.ActiveSeconds) * time.Second)
+}
+
+// Timer is the time left in a focus session or break, like "focus 12m".
+func (s *Status) Timer() string {
+	if s.Focus == nil {
+		return ""
+	}
+	kind := "focus"
+	if s.Focus.Break {
+		kind = "break"
+	}
+	return kind + " " + formatDuration(s.Focus.Remaining(s.At))
+}
+
+// State is one of empty, away, break, focus, running or paused, for
+// styling the status line.
+func (s *Status) State() string {
+	switch {
+	case s.Focus != nil && s.Focus.Break:
+		return "break"
+	case s.ID == "":
+		return "empty"
+	case s.Away:
+		return "away"
+	case s.Focus != nil:
+		return "focus"
+	case s.Running:
+		return "running"
+	}
+	return "paused"
+}
+
+// Text is a one-line summary with the description cut to width runes:
+// "fix auth bug 1h5m focus 12m". It's empty when there's nothing to show.
+func (s *Status) Text(width int) string {
+	var parts []string
+	if s.ID != "" {
+		parts = append(parts, truncate(width, s.Description), s.Elapsed())
+		if s.Away {
+			parts = append(parts, "away")
+		} else if !s.Running {
+			parts = append(parts, "paused")
+		}
+	}
+	if timer := s.Timer(); timer != "" {
+		parts = append(parts, timer)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Tooltip describes the stack in a few lines, for bars that show one.
+func (s *Status) Tooltip() string {
+	if s.ID == "" {
+		return fmt.Sprintf("No tasks in %s", s.Context)
+	}
+	lines := []string{s.Label, fmt.Sprintf("%s on it, %s in %s", s.Elapsed(), plural(s.Depth, "task"), s.Context)}
+	if s.Next != "" {
+		lines = append(lines, "Next: "+s.Next)
+	}
+	if timer := s.Timer(); timer != "" {
+		lines = append(lines, strings.ToUpper(timer[:1])+timer[1:]+" left")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// statusPresets are the named formats for memo status --format.
+var statusPresets = map[string]string{
+	"plain":    `{{if .ID}}{{.Label}} ({{.Elapsed}}){{end}}`,
+	"tmux":     `{{with .Text 30}}#[bold]{{tmux .}}#[nobold]{{end}}`,
+	"bash":     `{{with .Text 20}}[{{.}}] {{end}}`,
+	"zsh":      `{{with .Text 20}}[{{zsh .}}] {{end}}`,
+	"waybar":   `{"text": {{json (.Text 30)}}, "tooltip": {{json .Tooltip}}, "class": {{json .State}}}`,
+	"i3blocks": `{"full_text": {{json (.Text 40)}}, "short_text": {{json (.Text 15)}}}`,
+	"polybar":  `{{with .Text 30}}{{if eq $.State "running" "focus"}}%{F#a3be8c}{{else}}%{F#ebcb8b}{{end}}●%{F-} {{.}}{{end}}`,
+}
+
+var statusFuncs = template.FuncMap{
+	"truncate": truncate,
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// tmux and zsh would read # and % in the text as their own escapes.
+	"tmux": func(s string) string { return strings.ReplaceAll(s, "#", "##") },
+	"zsh":  func(s string) string { return strings.ReplaceAll(s, "%", "%%") },
+}
+
+// truncate cuts s to n runes, ending with an ellipsis. Zero means no limit.
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if n <= 0 || len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// parseStatusFormat returns the template for a preset name or template text.
+func parseStatusFormat(format string) (*template.Template, error) {
+	if preset, ok := statusPresets[format]; ok {
+		format = preset
+	}
+	return template.New("status").Funcs(statusFuncs).Parse(format)
+}
+
+func statusPresetNames() []string {
+	var names []string
+	for name := range statusPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runStatus prints the status line. It's meant to be polled, so it prints
+// nothing rather than start the daemon when none is running.
+func runStatus(format string) {
+	tmpl, err := parseStatusFormat(format)
+	if err != nil {
+		failUsage(fmt.Sprintf("memo status: bad --format: %v", err))
+	}
+	if !tryConnect(socketPath()) {
+		return
+	}
+	c := newClient()
+	c.context = selectedContext
+	c.http.Timeout = time.Second
+	var status Status
+	if err := c.call("GET", "/status", nil, &status); err != nil {
+		var netErr *net.OpError
+		if errors.As(err, &netErr) {
+			return
+		}
+		failErr(err)
+	}
+	if jsonOutput {
+		printJSON(status)
+		return
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, &status); err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: fmt.Sprintf("memo status: %v", err)})
+	}
+	if out.Len() > 0 {
+		fmt.Println(out.String())
+	}
+}
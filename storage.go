@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var storageBackends = []string{"json", "sqlite"}
+
+// Storage persists the daemon's state, its undo journal and the work log.
+type Storage interface {
+	LoadState() (*State, error)
+	SaveState(state *State) error
+	LoadJournal() (*Journal, error)
+	SaveJournal(j *Journal) error
+	AppendLog(entries ...LogEntry) error
+	// LoadLog returns the log entries stopped at or after since (or all of
+	// them if since is zero), oldest first.
+	LoadLog(since time.Time) ([]LogEntry, error)
+	Close() error
+}
+
+// openStorage opens the named backend in dir.
+func openStorage(backend, dir string) (Storage, error) {
+	switch backend {
+	case "json":
+		return jsonStorage{dir}, nil
+	case "sqlite":
+		return openSQLiteStorage(filepath.Join(dir, "memo.db"))
+	}
+	return nil, fmt.Errorf("unknown storage %q", backend)
+}
+
+// jsonStorage keeps state.json, journal.json and log.jsonl in a directory.
+type jsonStorage struct {
+	dir string
+}
+
+func (s jsonStorage) statePath() string   { return filepath.Join(s.dir, "state.json") }
+func (s jsonStorage) journalPath() string { return filepath.Join(s.dir, "journal.json") }
+func (s jsonStorage) logPath() string     { return filepath.Join(s.dir, "log.jsonl") }
+
+func (s jsonStorage) LoadState() (*State, error)     { return LoadState(s.statePath()) }
+func (s jsonStorage) SaveState(state *State) error   { return SaveState(state, s.statePath()) }
+func (s jsonStorage) LoadJournal() (*Journal, error) { return LoadJournal(s.journalPath()) }
+func (s jsonStorage) SaveJournal(j *Journal) error   { return SaveJournal(j, s.journalPath()) }
+func (s jsonStorage) Close() error                   { return nil }
+
+func (s jsonStorage) AppendLog(entries ...LogEntry) error {
+	for _, e := range entries {
+		if err := AppendLog(s.logPath(), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadLog reads the whole log; the file isn't indexed by time.
+func (s jsonStorage) LoadLog(since time.Time) ([]LogEntry, error) {
+	return LoadLog(s.logPath())
+}
+
+// isEmpty reports whether there's no data in the backend's files yet.
+func (s jsonStorage) isEmpty() bool {
+	for _, path := range []string{s.statePath(), s.logPath()} {
+		if _, err := os.Stat(path); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// migrateStorage copies the state, journal and log from one backend to
+// another, which must be empty.
+func migrateStorage(from, to Storage) (int, error) {
+	state, err := from.LoadState()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load state: %v", err)
+	}
+	journal, err := from.LoadJournal()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load journal: %v", err)
+	}
+	entries, err := from.LoadLog(time.Time{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to load log: %v", err)
+	}
+	if err := to.SaveState(state); err != nil {
+		return 0, fmt.Errorf("failed to save state: %v", err)
+	}
+	if err := to.SaveJournal(journal); err != nil {
+		return 0, fmt.Errorf("failed to save journal: %v", err)
+	}
+	if err := to.AppendLog(entries...); err != nil {
+		return 0, fmt.Errorf("failed to save log: %v", err)
+	}
+	return len(entries), nil
+}
+
+// runMigrate copies the data into another backend and switches the config
+// over to it. Once the target checks out, the daemon is stopped so nothing
+// changes mid-copy.
+func runMigrate(to string) {
+	cfg := config.Load()
+	if !slices.Contains(storageBackends, to) {
+		failUsage(fmt.Sprintf("memo migrate: --to must be one of %s", strings.Join(storageBackends, ", ")))
+	}
+	if to == cfg.Storage {
+		failUsage(fmt.Sprintf("Already using %s storage", to))
+	}
+
+	// Make sure the target can take the data before stopping anything.
+	target, err := openStorage(to, cfg.DataDir)
+	if err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: err.Error()})
+	}
+	defer target.Close()
+	if empty, ok := target.(interface{ isEmpty() bool }); ok && !empty.isEmpty() {
+		fail(cliError{Exit: exitError, Code: "error", Message: fmt.Sprintf("%s storage in %s already has data; remove it first", to, cfg.DataDir)})
+	}
+
+	killDaemon()
+	from, err := openStorage(cfg.Storage, cfg.DataDir)
+	if err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: err.Error()})
+	}
+	defer from.Close()
+
+	n, err := migrateStorage(from, target)
+	if err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: fmt.Sprintf("migration failed: %v", err)})
+	}
+	if err := SetConfigValue(configPath(), "storage", to); err != nil {
+		fail(cliError{Exit: exitError, Code: "error", Message: fmt.Sprintf("failed to save config: %v", err)})
+	}
+
+	if jsonOutput {
+		printJSON(map[string]any{"from": cfg.Storage, "to": to, "entries": n})
+		return
+	}
+	fmt.Printf("Migrated %s storage to %s (%d log entries)\n", cfg.Storage, to, n)
+	fmt.Printf("The old data is still in %s; delete it once you're happy.\n", cfg.DataDir)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tagWord matches a +tag or @project word in a description.
+var tagWord = regexp.MustCompile(`^[+@]\p{L}[\p{L}\p{N}_\-./]*$`)
+
+// parseDescription takes the +tag and @project words out of a description,
+// adding them to the given tags and project. The first @project wins unless
+// project is already set; later ones are left in the description.
+func parseDescription(description string, tags []string, project string) (string, []string, string) {
+	out := []string{}
+	var parsed []string
+	project = strings.TrimPrefix(project, "@")
+	for _, word := range strings.Fields(description) {
+		switch {
+		case !tagWord.MatchString(word):
+			out = append(out, word)
+		case word[0] == '+':
+			parsed = append(parsed, word[1:])
+		case project == "":
+			project = word[1:]
+		default:
+			out = append(out, word)
+		}
+	}
+	return strings.Join(out, " "), addTags(nil, append(tags, parsed...)...), project
+}
+
+// addTags appends the tags not already in tags, ignoring a leading +.
+func addTags(tags []string, more ...string) []string {
+	for _, tag := range more {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "+")
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// labelled returns a description followed by its tags and project, written
+// the way they are typed.
+func labelled(description string, tags []string, project string) string {
+	parts := []string{description}
+	for _, tag := range tags {
+		parts = append(parts, "+"+tag)
+	}
+	if project != "" {
+		parts = append(parts, "@"+project)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Label returns the task's description with its tags and project.
+func (t Task) Label() string {
+	return labelled(t.Description, t.Tags, t.Project)
+}
+
+// HasTag reports whether the task is tagged with tag.
+func (t Task) HasTag(tag string) bool {
+	return slices.Contains(t.Tags, tag)
+}
+
+// taskFilter selects stack tasks by tag or project. The zero value selects
+// every task.
+type taskFilter struct {
+	// Tags selects tasks with at least one of these tags.
+	Tags    []string
+	Project string
+}
+
+func (f taskFilter) IsZero() bool {
+	return len(f.Tags) == 0 && f.Project == ""
+}
+
+func (f taskFilter) Match(t Task) bool {
+	if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, t.HasTag) {
+		return false
+	}
+	return f.Project == "" || t.Project == f.Project
+}
+
+func (f taskFilter) String() string {
+	return strings.TrimSpace(labelled("", f.Tags, f.Project))
+}
+
+// stackFilters returns a filter for each tag and project used on the stack,
+// in order of first appearance.
+func stackFilters(tasks []Task) []taskFilter {
+	var tags, projects []string
+	for _, t := range tasks {
+		tags = addTags(tags, t.Tags...)
+		if t.Project != "" && !slices.Contains(projects, t.Project) {
+			projects = append(projects, t.Project)
+		}
+	}
+	var filters []taskFilter
+	for _, tag := range tags {
+		filters = append(filters, taskFilter{Tags: []string{tag}})
+	}
+	for _, p := range projects {
+		filters = append(filters, taskFilter{Project: p})
+	}
+	return filters
+}
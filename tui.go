@@ -2,34 +2,324 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+type tuiMode int
+
+const (
+	modeNormal tuiMode = iota
+	// modeInput reads a description for a new or edited task.
+	modeInput
+	// modeConfirm asks before completing or dropping a task.
+	modeConfirm
+)
+
 type tuiModel struct {
-	tasks    []Task
-	cursor   int
-	selected int // original index chosen by enter, -1 if none
+	tasks []Task
+	// revision is the revision of the stack tasks came from.
+	revision int64
+	visible  []int // indexes into tasks matching filter
+	filter   taskFilter
+	cursor   int // index into visible
 	client   *memoClient
+
+	mode tuiMode
+	// action is what the input or confirmation is for: push, queue, insert,
+	// edit, pop or drop. target is the ID of the task it applies to.
+	action string
+	target string
+	input  []rune
+
+	status   string
+	showHelp bool
+
+	// events streams changes from the daemon so the stack stays current;
+	// nil once the stream has closed.
+	events <-chan Event
+}
+
+// tickMsg redraws the view so running times stay current.
+type tickMsg time.Time
+
+// changedMsg reports that the daemon changed the stack.
+type changedMsg struct {
+	closed bool
+}
+
+// stackMsg carries the stack as reloaded after a change. focus is the ID of
+// the task to put the cursor on, if it's still there.
+type stackMsg struct {
+	stack  *TaskStack
+	focus  string
+	status string
+	err    error
 }
 
-func newTUIModel(tasks []Task, client *memoClient) tuiModel {
-	return tuiModel{
-		tasks:    tasks,
+func newTUIModel(stack *TaskStack, filter taskFilter, client *memoClient, events <-chan Event) tuiModel {
+	m := tuiModel{
+		tasks:    stack.Tasks,
+		revision: stack.Revision,
 		cursor:   0,
-		selected: -1,
 		client:   client,
+		events:   events,
+	}
+	m.setFilter(filter)
+	return m
+}
+
+func (m *tuiModel) setFilter(f taskFilter) {
+	m.filter = f
+	m.visible = nil
+	for i, task := range m.tasks {
+		if f.Match(task) {
+			m.visible = append(m.visible, i)
+		}
+	}
+	m.cursor = min(m.cursor, max(len(m.visible)-1, 0))
+}
+
+// nextFilter cycles through no filter and each tag and project on the stack.
+func (m *tuiModel) nextFilter() {
+	filters := append([]taskFilter{{}}, stackFilters(m.tasks)...)
+	next := 0
+	for i, f := range filters {
+		if f.String() == m.filter.String() {
+			next = (i + 1) % len(filters)
+		}
+	}
+	m.cursor = 0
+	m.setFilter(filters[next])
+}
+
+// current returns the highlighted task, or nil if no task is shown.
+func (m tuiModel) current() *Task {
+	if len(m.visible) == 0 {
+		return nil
+	}
+	return &m.tasks[m.visible[m.cursor]]
+}
+
+// focus moves the cursor to the task with the given ID, if it's shown.
+func (m *tuiModel) focus(id string) {
+	for n, i := range m.visible {
+		if m.tasks[i].ID == id {
+			m.cursor = n
+		}
 	}
 }
 
 func (m tuiModel) Init() tea.Cmd {
+	return tea.Batch(tick(), m.waitForChange())
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+// waitForChange waits for the next event from the daemon.
+func (m tuiModel) waitForChange() tea.Cmd {
+	if m.events == nil {
+		return nil
+	}
+	events := m.events
+	return func() tea.Msg {
+		_, ok := <-events
+		// Drain events that arrived together so one reload covers them.
+		for ok {
+			select {
+			case _, ok = <-events:
+			default:
+				return changedMsg{}
+			}
+		}
+		return changedMsg{closed: true}
+	}
+}
+
+// reload fetches the stack from the daemon.
+func reload(c *memoClient, focus, status string) tea.Msg {
+	stack, err := c.FetchStack()
+	if err != nil {
+		return stackMsg{err: err}
+	}
+	return stackMsg{stack: stack, focus: focus, status: status}
+}
+
+// conflictRetries is how many times a change refused because the stack
+// changed underneath it is retried against the latest stack.
+const conflictRetries = 3
+
+// retry calls change with the revision of the stack the TUI shows, and
+// again with the latest stack each time the daemon reports that the stack
+// has changed since. change returns the status to show, or "" to leave the
+// stack alone.
+func (m tuiModel) retry(focus string, change func(stack *TaskStack) (string, error)) tea.Cmd {
+	c := m.client
+	stack := &TaskStack{Tasks: m.tasks, Revision: m.revision}
+	return func() tea.Msg {
+		for attempt := 0; ; attempt++ {
+			status, err := change(stack)
+			if isStatus(err, http.StatusConflict) && attempt < conflictRetries {
+				if stack, err = c.FetchStack(); err != nil {
+					return stackMsg{err: err}
+				}
+				continue
+			}
+			if err != nil {
+				return stackMsg{err: err}
+			}
+			return reload(c, focus, status)
+		}
+	}
+}
+
+// send posts body to a daemon endpoint and reloads the stack, reporting
+// status if it succeeded.
+func (m tuiModel) send(path string, body any, focus, status string) tea.Cmd {
+	c := m.client
+	return m.retry(focus, func(stack *TaskStack) (string, error) {
+		return status, c.callAt(stack.Revision, "POST", path, body, nil)
+	})
+}
+
+// move swaps the highlighted task with the shown task above (by -1) or
+// below (by 1) it. If the stack changed elsewhere in the meantime, the swap
+// is applied to the latest stack instead.
+func (m tuiModel) move(by int) tea.Cmd {
+	n := m.cursor + by
+	if len(m.visible) == 0 || n < 0 || n >= len(m.visible) {
+		return nil
+	}
+	task, other := m.tasks[m.visible[m.cursor]], m.tasks[m.visible[n]]
+	c := m.client
+	return m.retry(task.ID, func(stack *TaskStack) (string, error) {
+		a, _ := stack.Find(task.ID)
+		b, _ := stack.Find(other.ID)
+		if a < 0 || b < 0 {
+			return "The stack changed; move cancelled.", nil
+		}
+		ids := make([]string, stack.Len())
+		for i, t := range stack.Tasks {
+			ids[i] = t.ID
+		}
+		ids[a], ids[b] = ids[b], ids[a]
+		return "Moved: " + task.Description, c.callAt(stack.Revision, "POST", "/reorder", map[string][]string{"ids": ids}, nil)
+	})
+}
+
+// startInput asks for a description for action, starting from text.
+func (m *tuiModel) startInput(action, target, text string) {
+	m.mode = modeInput
+	m.action = action
+	m.target = target
+	m.input = []rune(text)
+}
+
+// submitInput carries out the action the input was for.
+func (m tuiModel) submitInput() tea.Cmd {
+	text := strings.TrimSpace(string(m.input))
+	if text == "" {
+		return nil
+	}
+	switch m.action {
+	case "push":
+		return m.send("/push", map[string]string{"description": text}, "", "Started: "+text)
+	case "queue":
+		return m.send("/queue", map[string]string{"description": text}, "", "Queued: "+text)
+	case "insert":
+		return m.send("/insert", map[string]string{"description": text, "after": m.target}, "", "Inserted: "+text)
+	case "edit":
+		// The input started out as the whole label, so what's left of the
+		// tags and project is what the task should have.
+		return m.send("/edit", map[string]any{"id": m.target, "description": text, "replace": true}, m.target, "Edited: "+text)
+	}
 	return nil
 }
 
+// updateInput handles keys while a description is being typed.
+func (m tuiModel) updateInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.mode = modeNormal
+	case tea.KeyEnter:
+		m.mode = modeNormal
+		return m, m.submitInput()
+	case tea.KeyBackspace:
+		if len(m.input) > 0 {
+			m.input = m.input[:len(m.input)-1]
+		}
+	case tea.KeyCtrlU:
+		m.input = nil
+	case tea.KeySpace:
+		m.input = append(m.input, ' ')
+	case tea.KeyRunes:
+		m.input = append(m.input, msg.Runes...)
+	}
+	return m, nil
+}
+
+// updateConfirm handles the answer to a confirmation.
+func (m tuiModel) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.mode = modeNormal
+	if msg.String() != "y" {
+		m.status = "Cancelled."
+		return m, nil
+	}
+	_, task := (&TaskStack{Tasks: m.tasks}).Find(m.target)
+	if task == nil {
+		return m, nil
+	}
+	verb := "Done: "
+	if m.action == "drop" {
+		verb = "Dropped: "
+	}
+	return m, m.send("/"+m.action, map[string]string{"id": task.ID}, "", verb+task.Description)
+}
+
 func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tickMsg:
+		return m, tick()
+	case changedMsg:
+		if msg.closed {
+			m.events = nil
+			m.status = "Lost connection to the daemon; the stack may be out of date."
+			return m, nil
+		}
+		c := m.client
+		return m, tea.Batch(m.waitForChange(), func() tea.Msg { return reload(c, "", "") })
+	case stackMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("error: %v", msg.err)
+			return m, nil
+		}
+		focus := msg.focus
+		if focus == "" && m.current() != nil {
+			focus = m.current().ID
+		}
+		m.tasks, m.revision = msg.stack.Tasks, msg.stack.Revision
+		m.setFilter(m.filter)
+		m.focus(focus)
+		if msg.status != "" {
+			m.status = msg.status
+		}
 	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		switch m.mode {
+		case modeInput:
+			return m.updateInput(msg)
+		case modeConfirm:
+			return m.updateConfirm(msg)
+		}
+		m.status = ""
+		task := m.current()
 		switch msg.String() {
 		case "q", "esc":
 			return m, tea.Quit
@@ -38,72 +328,141 @@ func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cursor--
 			}
 		case "down", "j":
-			if m.cursor < len(m.tasks)-1 {
+			if m.cursor < len(m.visible)-1 {
 				m.cursor++
 			}
+		case "shift+up", "K":
+			return m, m.move(-1)
+		case "shift+down", "J":
+			return m, m.move(1)
 		case "enter":
-			m.selected = m.cursor
-			return m, tea.Quit
+			if task != nil && task.ID != m.tasks[0].ID {
+				return m, m.send("/resume", map[string]string{"id": task.ID}, task.ID, "Resuming: "+task.Description)
+			}
+		case "p":
+			m.startInput("push", "", "")
+		case "a":
+			m.startInput("queue", "", "")
+		case "o":
+			if task != nil {
+				m.startInput("insert", task.ID, "")
+			}
+		case "e":
+			if task != nil {
+				m.startInput("edit", task.ID, task.Label())
+			}
+		case "d", "x":
+			if task != nil {
+				m.mode = modeConfirm
+				m.action = map[string]string{"d": "pop", "x": "drop"}[msg.String()]
+				m.target = task.ID
+			}
+		case "u":
+			return m, m.send("/undo", nil, "", "Undone.")
+		case "f":
+			m.nextFilter()
+		case "?":
+			m.showHelp = !m.showHelp
 		}
 	}
 	return m, nil
 }
 
+var inputPrompts = map[string]string{
+	"push":   "Push: ",
+	"queue":  "Queue: ",
+	"insert": "Insert below: ",
+	"edit":   "Edit: ",
+}
+
+const shortHelp = "enter resume · p push · a queue · e edit · d done · x drop · ? help · q quit"
+
+const fullHelp = `  ↑/k ↓/j            move cursor
+  shift+↑/K shift+↓/J move task up or down
+  enter              resume the highlighted task
+  p                  push a new task on top
+  a                  queue a new task at the bottom
+  o                  insert a new task below the highlighted one
+  e                  edit the highlighted task
+  d                  complete the highlighted task
+  x                  drop the highlighted task
+  u                  undo the last change
+  f                  cycle through tags and projects
+  ?                  hide help
+  q                  quit`
+
 func (m tuiModel) View() string {
 	s := ""
-	for i, task := range m.tasks {
+	if !m.filter.IsZero() {
+		s += fmt.Sprintf("Showing %s (f for more)\n", m.filter)
+	}
+	if len(m.tasks) == 0 {
+		s += "  No tasks. Press p to push one.\n"
+	} else if len(m.visible) == 0 {
+		s += "  No matching tasks.\n"
+	}
+	now := time.Now()
+	for n, i := range m.visible {
+		task := m.tasks[i]
 		cursor := "  "
-		if i == m.cursor {
+		if n == m.cursor {
 			cursor = "→ "
 		}
 
-		desc := task.Description
-		if i == 0 {
-			desc = fmt.Sprintf("%s (working for %s)", desc, formatDuration(time.Since(task.StartedAt)))
+		desc := task.Label()
+		if m.mode == modeInput && m.action == "edit" && task.ID == m.target {
+			desc = string(m.input) + "█"
+		} else if i == 0 {
+			desc = fmt.Sprintf("%s (%s)", desc, workingFor(task, now))
 		}
 
 		s += fmt.Sprintf("%s%s\n", cursor, desc)
+		if n == m.cursor {
+			for _, note := range task.Notes {
+				s += fmt.Sprintf("      [%s] %s\n", formatTime(note.At), note.Text)
+			}
+		}
+	}
+
+	s += "\n"
+	switch {
+	case m.mode == modeInput && m.action != "edit":
+		s += inputPrompts[m.action] + string(m.input) + "█\n"
+		s += "enter: save  esc: cancel\n"
+	case m.mode == modeInput:
+		s += "enter: save  esc: cancel\n"
+	case m.mode == modeConfirm:
+		if _, task := (&TaskStack{Tasks: m.tasks}).Find(m.target); task != nil {
+			verb := "Complete"
+			if m.action == "drop" {
+				verb = "Drop"
+			}
+			s += fmt.Sprintf("%s %q? (y/n)\n", verb, task.Description)
+		}
+	case m.showHelp:
+		s += fullHelp + "\n"
+	default:
+		if m.status != "" {
+			s += m.status + "\n"
+		}
+		s += shortHelp + "\n"
 	}
 	return s
 }
 
-func runTUI(client *memoClient) {
+func runTUI(client *memoClient, filter taskFilter) {
 	stack, err := client.FetchStack()
 	if err != nil {
 		fmt.Printf("error: %v\n", err)
 		return
 	}
 
-	if stack.Len() < 2 {
-		client.Stack()
-		return
-	}
-
-	m := newTUIModel(stack.Tasks, client)
+	// Without the event stream the TUI still works, it just won't see
+	// changes made elsewhere.
+	events, _ := client.Subscribe()
+	m := newTUIModel(stack, filter, client, events)
 	p := tea.NewProgram(m)
-	finalModel, err := p.Run()
-	if err != nil {
+	if _, err := p.Run(); err != nil {
 		fmt.Printf("error: %v\n", err)
-		return
-	}
-
-	final := finalModel.(tuiModel)
-	if final.selected > 0 {
-		// Build reorder: move selected to top, shift others down
-		order := make([]int, len(final.tasks))
-		order[0] = final.selected
-		pos := 1
-		for i := range final.tasks {
-			if i != final.selected {
-				order[pos] = i
-				pos++
-			}
-		}
-		if err := client.Reorder(order); err != nil {
-			fmt.Printf("error: %v\n", err)
-			return
-		}
-		fmt.Printf("Paused: %s\n", final.tasks[0].Description)
-		fmt.Printf("Resuming: %s\n", final.tasks[final.selected].Description)
 	}
 }